@@ -0,0 +1,62 @@
+package app
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	acceptHeader    = "Accept"
+	contentTypeText = "text/plain; charset=utf-8"
+)
+
+// prefersText reports whether the client rates plain text above JSON in the
+// Accept header. JSON is the default and wins ties.
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get(acceptHeader)
+	if accept == "" {
+		return false
+	}
+	return acceptQuality(accept, "text/plain") > acceptQuality(accept, "application/json")
+}
+
+// acceptQuality returns the q-value the Accept header gives the media type,
+// taken from the most specific of the matching ranges. Zero means the type
+// is not acceptable.
+func acceptQuality(accept, mediaType string) float64 {
+	typ := mediaType[:strings.IndexByte(mediaType, '/')]
+
+	quality, specificity := 0.0, -1
+	for _, item := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+
+		var rangeSpecificity int
+		switch mediaRange {
+		case mediaType:
+			rangeSpecificity = 2
+		case typ + "/*":
+			rangeSpecificity = 1
+		case "*/*":
+			rangeSpecificity = 0
+		default:
+			continue
+		}
+		if rangeSpecificity < specificity {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		quality, specificity = q, rangeSpecificity
+	}
+	return quality
+}
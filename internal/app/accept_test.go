@@ -0,0 +1,67 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrefersText(t *testing.T) {
+	tests := map[string]bool{
+		"":                                   false,
+		"application/json":                   false,
+		"text/plain":                         true,
+		"text/*":                             true,
+		"*/*":                                false,
+		"text/plain, application/json":       false,
+		"text/plain, application/json;q=0.5": true,
+		"text/plain;q=0.1, */*":              false,
+		"application/*;q=0.2, text/plain":    true,
+	}
+	for accept, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/crawler", nil)
+		r.Header.Set(acceptHeader, accept)
+		if got := prefersText(r); got != want {
+			t.Errorf("prefersText(%q) = %t, want %t", accept, got, want)
+		}
+	}
+}
+
+func TestWriteErrorNegotiatesFormat(t *testing.T) {
+	errBad := errors.New("bad request: no URLs passed")
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{accept: "", contentType: contentTypeJSON},
+		{accept: "application/json", contentType: contentTypeJSON},
+		{accept: "text/plain", contentType: contentTypeText},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/crawler", nil)
+		r.Header.Set(acceptHeader, tt.accept)
+		writeResponse(w, r, errBad, http.StatusBadRequest)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Accept %q: status = %d, want %d", tt.accept, w.Code, http.StatusBadRequest)
+		}
+		if got := w.Header().Get(contentTypeHeader); got != tt.contentType {
+			t.Errorf("Accept %q: %s = %q, want %q", tt.accept, contentTypeHeader, got, tt.contentType)
+		}
+
+		message := w.Body.String()
+		if tt.contentType == contentTypeJSON {
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Errorf("Accept %q: decode %q: %v", tt.accept, w.Body.String(), err)
+			}
+			message = resp.Error
+		}
+		if message != errBad.Error() {
+			t.Errorf("Accept %q: error = %q, want %q", tt.accept, message, errBad.Error())
+		}
+	}
+}
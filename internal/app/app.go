@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/alexeykhan/multiplexer/pkg/clock"
 	"github.com/alexeykhan/multiplexer/pkg/closer"
 	"github.com/alexeykhan/multiplexer/pkg/crawler"
 	"github.com/alexeykhan/multiplexer/pkg/listener"
@@ -22,18 +24,114 @@ type (
 	}
 	Config struct {
 		HTTPPort        uint16 // Public HTTP port.
+		MonitoringPort  uint16 // Port serving /readiness apart from MaxConnections, zero disables.
 		MaxConnections  uint16 // Number of simultaneous connections.
+		RejectOverflow  bool   // Close connections over MaxConnections instead of queueing them.
 		GracefulDelay   time.Duration
 		GracefulTimeout time.Duration
+		IdleShutdown    time.Duration // Shut down after no requests for this long, zero disables.
+		SpillResults    bool          // Keep crawled bodies in a temp directory instead of memory.
+
+		// WriteTimeout bounds each write of a response to the client, zero
+		// means no limit. Spilled responses are streamed in chunks and the
+		// timeout is reset for every chunk: slow clients that keep reading
+		// get the whole response, stuck ones are cut off.
+		WriteTimeout time.Duration
+
+		// KeepAlivePeriod is the interval of TCP keep-alive probes on client
+		// connections, dead ones are closed and release their spots. Zero
+		// means 15 seconds, negative disables probes.
+		KeepAlivePeriod time.Duration
+
+		// AcceptMissingContentType parses bodies of requests without the
+		// Content-Type header as JSON. Requests with any other content type
+		// than JSON or a form upload are still rejected with 415.
+		AcceptMissingContentType bool
+
+		// MaxURLs answers with 400 to requests with more URLs than this,
+		// zero means 20.
+		MaxURLs uint16
+
+		// RejectDuplicateURLs answers with 400 to requests that list the
+		// same URL more than once, otherwise every occurrence is crawled.
+		RejectDuplicateURLs bool
+
+		// MaxDistinctHosts answers with 400 to requests whose URLs point to
+		// more different hosts than this, zero means unlimited.
+		MaxDistinctHosts uint16
+
+		// IdempotencyTTL keeps successful results of requests that carry
+		// the Idempotency-Key header for this long, zero disables. A retry
+		// with the same key and API key only crawls the URLs that didn't
+		// succeed before. At most IdempotencyMaxKeys keys are kept, 1000 if
+		// zero: once full, the key whose results expire first is evicted.
+		// Spilled bodies aren't kept. Fail-fast batches return no results
+		// to keep, set Crawler.MaxFailures or BatchTimeout to keep the ones
+		// that succeeded.
+		IdempotencyTTL     time.Duration
+		IdempotencyMaxKeys int
+
+		// WarmHosts are pre-dialed on start so the first requests to them skip
+		// the connection setup. Unused warm connections expire after the
+		// crawler transport's IdleConnTimeout.
+		WarmHosts []string
+
+		// SelfTestURLs are crawled by GET SelfTestPath ("/selftest" if empty)
+		// to check that the instance can reach upstreams, proxy included.
+		// The endpoint is only served if both SelfTestURLs and SelfTestKey
+		// are set, requests must pass the key in the X-Api-Key header.
+		SelfTestURLs []string
+		SelfTestPath string
+		SelfTestKey  string
+
+		// ConfigKey serves the effective configuration at GET /config to
+		// requests that pass it in the X-Api-Key header, empty disables.
+		// API keys and other secrets are redacted.
+		ConfigKey string
+
+		// Simultaneous crawls allowed per API key passed in the X-Api-Key
+		// header. Keys missing in KeyQuotas, including requests without
+		// a key, get DefaultKeyQuota. Zero means unlimited.
+		KeyQuotas       map[string]uint16
+		DefaultKeyQuota uint16
+
+		Crawler crawler.Config // Settings for outgoing requests.
+
+		// HostPolicyFile is a JSON file like {"allow": [...], "deny": [...]}
+		// with the crawler's host policy, loaded on start and reloaded on
+		// SIGHUP without a restart. Empty keeps Crawler.HostPolicy.
+		HostPolicyFile string
+
+		// Auditor gets an entry per URL of every crawl request, including
+		// the ones refused for exceeding the key quota. Nil disables it.
+		Auditor Auditor
+
+		// Clock measures graceful shutdown and idle periods, nil means the
+		// system clock. The crawler gets it too unless Crawler.Clock is set,
+		// and so do the listeners.
+		Clock clock.Clock
 	}
 	app struct {
+		// crawlTime is the moving average of crawl durations in nanoseconds.
+		// It's accessed atomically, so it comes first to stay 64-bit aligned
+		// on 32-bit platforms.
+		crawlTime int64
+
 		http struct {
-			server   *http.ServeMux
-			listener net.Listener
+			server     *http.ServeMux
+			listener   net.Listener
+			monitoring net.Listener // Nil unless MonitoringPort is set.
 		}
-		config  Config
-		closer  closer.Closer
-		crawler crawler.Crawler
+		config      Config
+		clock       clock.Clock
+		closer      closer.Closer
+		crawler     crawler.Crawler
+		activity    activity
+		quotas      quotas
+		idempotency idempotency
+		lameDuck    int32
+		spillDir    string
+		auditor     Auditor
 	}
 )
 
@@ -47,6 +145,8 @@ var (
 		MaxConnections:  100,
 		GracefulDelay:   3 * time.Second,
 		GracefulTimeout: 3 * time.Second,
+		MaxURLs:         defaultMaxURLs,
+		Crawler:         crawler.DefaultConfig(),
 	}
 )
 
@@ -57,24 +157,82 @@ func New() (App, error) {
 
 // NewWithConfig creates a new App instance with custom settings.
 func NewWithConfig(cfg Config) (_ App, err error) {
-	a := &app{config: cfg}
+	a := &app{config: cfg, clock: clock.OrNew(cfg.Clock), auditor: noopAuditor{}}
+	if cfg.Auditor != nil {
+		a.auditor = cfg.Auditor
+	}
 
-	// Init a closer.
-	a.closer = closer.New(syscall.SIGTERM, syscall.SIGINT, os.Interrupt)
+	// Init a closer, it stops waiting for the shutdown once the graceful
+	// one had time to report its own timeout.
+	a.closer = closer.NewWithConfig(closer.Config{
+		Signals: []os.Signal{syscall.SIGTERM, syscall.SIGINT, os.Interrupt},
+		Timeout: a.config.GracefulDelay + a.config.GracefulTimeout + closeMargin,
+		Clock:   a.clock,
+	})
 
 	// Set up handlers for routes.
 	a.http.server = http.NewServeMux()
 	a.http.server.Handle("/crawler", a.handler())
+	a.http.server.Handle("/readiness", a.readinessHandler())
+	if len(a.config.SelfTestURLs) > 0 && a.config.SelfTestKey != "" {
+		a.http.server.Handle(a.selfTestPath(), a.selfTestHandler())
+	}
+	if a.config.ConfigKey != "" {
+		a.http.server.Handle("/config", a.configHandler())
+	}
+
+	// Prepare a directory for bodies that don't have to stay in memory.
+	crawlerCfg := a.config.Crawler
+	if crawlerCfg.Clock == nil {
+		crawlerCfg.Clock = a.clock
+	}
+	if a.config.SpillResults {
+		if a.spillDir, err = ioutil.TempDir("", "multiplexer-"); err != nil {
+			return nil, fmt.Errorf("create spill directory: %w", err)
+		}
+		crawlerCfg.SpillDir = a.spillDir
+		defer func() {
+			if err != nil {
+				a.removeSpillDir()
+			}
+		}()
+	}
 
 	// Init a crawler instance for reusable purposes.
-	a.crawler = crawler.New()
+	a.config.Crawler = crawlerCfg
+	if a.crawler, err = crawler.NewWithConfig(crawlerCfg); err != nil {
+		return nil, fmt.Errorf("create crawler: %w", err)
+	}
+	if a.config.HostPolicyFile != "" {
+		if err = a.loadHostPolicy(); err != nil {
+			return nil, err
+		}
+	}
 
 	// Set up new listener.
 	network, address := "tcp", fmt.Sprintf(":%d", a.config.HTTPPort)
-	if a.http.listener, err = listener.New(network, address, a.config.MaxConnections); err != nil {
+	listenerCfg := listener.Config{
+		MaxConnections:  a.config.MaxConnections,
+		RejectOverflow:  a.config.RejectOverflow,
+		WriteTimeout:    a.config.WriteTimeout,
+		KeepAlivePeriod: a.config.KeepAlivePeriod,
+		Clock:           a.clock,
+	}
+	if a.http.listener, err = listener.NewWithConfig(network, address, listenerCfg); err != nil {
 		return nil, fmt.Errorf("listen on tcp port %d: %w", a.config.HTTPPort, err)
 	}
 
+	// The connection limit applies before requests are parsed, so it can't
+	// let probes through by path: they get a port of their own instead.
+	if a.config.MonitoringPort > 0 {
+		address = fmt.Sprintf(":%d", a.config.MonitoringPort)
+		monitoringCfg := listener.Config{WriteTimeout: a.config.WriteTimeout, KeepAlivePeriod: a.config.KeepAlivePeriod, Clock: a.clock}
+		if a.http.monitoring, err = listener.NewWithConfig(network, address, monitoringCfg); err != nil {
+			_ = a.http.listener.Close()
+			return nil, fmt.Errorf("listen on tcp port %d: %w", a.config.MonitoringPort, err)
+		}
+	}
+
 	return a, nil
 }
 
@@ -83,36 +241,99 @@ func (a *app) Run() error {
 	port := a.http.listener.Addr().(*net.TCPAddr).Port
 	log.Printf("app started on port: %d\n", port)
 
-	srv := &http.Server{Handler: a.http.server}
+	srv := &http.Server{Handler: a.trackActivity(a.http.server)}
+	served := make(chan struct{})
 	go func() {
-		if err := srv.Serve(a.http.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		defer close(served)
+
+		// Temporary accept errors are retried by srv.Serve itself, whatever
+		// it returns leaves the server without a listener to serve. The
+		// listener is closed on purpose when the shutdown stops accepting.
+		err := srv.Serve(a.http.listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
 			log.Printf("http: %s\n", err.Error())
 			a.closer.Close()
 		}
 	}()
 
+	var monitoringSrv *http.Server
+	if a.http.monitoring != nil {
+		port := a.http.monitoring.Addr().(*net.TCPAddr).Port
+		log.Printf("app: serving monitoring on port: %d\n", port)
+
+		monitoringSrv = &http.Server{Handler: a.monitoringHandler()}
+		go func() {
+			if err := monitoringSrv.Serve(a.http.monitoring); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("http: monitoring: %s\n", err.Error())
+				a.closer.Close()
+			}
+		}()
+	}
+
+	// Pre-dial known hosts in the background, startup doesn't wait for it.
+	if len(a.config.WarmHosts) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		a.closer.Add(func() error {
+			cancel()
+			return nil
+		})
+		go a.crawler.Warm(ctx, a.config.WarmHosts)
+	}
+
 	// Given condition: support graceful shutdown.
 	a.closer.Add(func() error {
-		log.Printf("http: setting graceful timeout: %.2fs\n", a.config.GracefulTimeout.Seconds())
-		ctx, cancel := context.WithTimeout(context.Background(), a.config.GracefulTimeout)
-		defer cancel()
-
-		log.Printf("http: awaiting traffic to stop: %.2fs\n", a.config.GracefulDelay.Seconds())
-		time.Sleep(a.config.GracefulDelay)
+		return a.shutdown(srv, monitoringSrv, served)
+	})
 
-		log.Println("http: shutting down: disabling keep-alive")
-		srv.SetKeepAlivesEnabled(false)
+	// Let operators drain the instance before shutting it down.
+	if len(lameDuckSignals) > 0 {
+		stop := make(chan struct{})
+		a.closer.Add(func() error {
+			close(stop)
+			return nil
+		})
+		go a.watchLameDuck(stop)
+	}
 
-		if err := srv.Shutdown(ctx); err != nil {
-			return fmt.Errorf("http: shutting down: %w", err)
-		}
+	// Let operators change the host policy of a live instance.
+	if a.config.HostPolicyFile != "" && len(reloadSignals) > 0 {
+		stop := make(chan struct{})
+		a.closer.Add(func() error {
+			close(stop)
+			return nil
+		})
+		go a.watchHostPolicy(stop)
+	}
 
-		log.Println("http: gracefully stopped")
-		return nil
-	})
+	// Stop the server once it has been idle for too long.
+	if a.config.IdleShutdown > 0 {
+		stop := make(chan struct{})
+		a.closer.Add(func() error {
+			close(stop)
+			return nil
+		})
+		go a.watchIdle(stop)
+	}
 
 	// Waiting for signal to release all resources.
 	a.closer.Wait()
 
 	return nil
 }
+
+// monitoringHandler serves the routes of the monitoring port.
+func (a *app) monitoringHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/readiness", a.readinessHandler())
+	return mux
+}
+
+// removeSpillDir deletes spilled bodies left behind by interrupted requests.
+func (a *app) removeSpillDir() {
+	if a.spillDir == "" {
+		return
+	}
+	if err := os.RemoveAll(a.spillDir); err != nil {
+		log.Println("app: remove spill directory:", err)
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+// newTestApp returns an app of the config that listens on a random port and
+// is served by a test server instead. The crawler logs nothing.
+func newTestApp(t *testing.T, cfg Config) (*app, *httptest.Server) {
+	t.Helper()
+	cfg.Crawler.LogLevel = crawler.LogLevelSilent
+	instance, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() error = %v", err)
+	}
+	a := instance.(*app)
+	_ = a.http.listener.Close()
+
+	srv := httptest.NewServer(a.trackActivity(a.http.server))
+	t.Cleanup(func() {
+		srv.Close()
+		_ = a.crawler.Close()
+		a.removeSpillDir()
+	})
+	return a, srv
+}
+
+// newUpstream returns a server that answers every request with the JSON body.
+func newUpstream(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(contentTypeHeader, contentTypeJSON)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// postURLs sends the URLs to the crawler endpoint of the server with the
+// headers, if any, and returns the response along with its body.
+func postURLs(t *testing.T, srv *httptest.Server, urls []string, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	body, err := json.Marshal(map[string][]string{"urls": urls})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/crawler", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set(contentTypeHeader, contentTypeJSON)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /crawler: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return resp, data
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+type (
+	// Auditor records who had which URLs crawled and how it went. Unlike
+	// the log, it gets one structured entry per URL of every crawl request,
+	// meant to be stored for good. Audit is called from request handlers
+	// and must be safe for concurrent use.
+	Auditor interface {
+		Audit(entry AuditEntry)
+	}
+	AuditEntry struct {
+		Time       time.Time // When the outcome of the URL was known.
+		BatchID    string    // The X-Batch-Id of the request.
+		KeyID      string    // Hash of the X-Api-Key, empty without one.
+		ClientIP   string
+		URL        string
+		StatusCode int    // Zero if the URL wasn't crawled.
+		Error      string // Empty if the URL was crawled successfully.
+	}
+	noopAuditor struct{}
+)
+
+// Interface compliance check.
+var _ Auditor = noopAuditor{}
+
+// errNotCrawled stands in for failures that were tolerated, their errors
+// are only logged by the crawler.
+const errNotCrawled = "failed to crawl"
+
+func (noopAuditor) Audit(AuditEntry) {}
+
+// audit records an entry per URL of the request: with the result of the URL
+// if there is one, otherwise with err, the reason why the URL has no result.
+func (a *app) audit(r *http.Request, batch string, urls []string, results []crawler.Result, err error) {
+	byURL := make(map[string][]crawler.Result, len(results))
+	for _, res := range results {
+		byURL[res.SourceURL] = append(byURL[res.SourceURL], res)
+	}
+
+	entry := AuditEntry{
+		Time:     a.clock.Now(),
+		BatchID:  batch,
+		KeyID:    keyID(r.Header.Get(apiKeyHeader)),
+		ClientIP: r.RemoteAddr,
+	}
+	if host, _, splitErr := net.SplitHostPort(r.RemoteAddr); splitErr == nil {
+		entry.ClientIP = host
+	}
+
+	for _, u := range urls {
+		entry.URL, entry.StatusCode, entry.Error = u, 0, errNotCrawled
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		// Duplicate URLs get their results in turn.
+		if res := byURL[u]; len(res) > 0 {
+			entry.StatusCode, entry.Error = res[0].StatusCode, ""
+			if resErr := res[0].Err(); resErr != nil {
+				entry.Error = resErr.Error()
+			}
+			byURL[u] = res[1:]
+		}
+		a.auditor.Audit(entry)
+	}
+}
+
+// keyID identifies the API key in the audit log without revealing it.
+func keyID(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
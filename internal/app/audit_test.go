@@ -0,0 +1,96 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+// recordingAuditor keeps every entry it gets.
+type recordingAuditor struct {
+	sync.Mutex
+	entries []AuditEntry
+}
+
+func (ra *recordingAuditor) Audit(entry AuditEntry) {
+	ra.Lock()
+	ra.entries = append(ra.entries, entry)
+	ra.Unlock()
+}
+
+// take returns the entries recorded so far and forgets them.
+func (ra *recordingAuditor) take() []AuditEntry {
+	ra.Lock()
+	defer ra.Unlock()
+	entries := ra.entries
+	ra.entries = nil
+	return entries
+}
+
+func TestAuditEntryPerURL(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	auditor := &recordingAuditor{}
+	a, srv := newTestApp(t, Config{
+		Auditor:         auditor,
+		DefaultKeyQuota: 1,
+		Crawler:         crawler.Config{ContinueOnError: true},
+	})
+	urls := []string{upstream.URL + "/a", upstream.URL + "/bad", upstream.URL + "/a"}
+	header := http.Header{apiKeyHeader: {"secret"}}
+
+	resp, body := postURLs(t, srv, urls, header)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+	}
+	entries := auditor.take()
+	if len(entries) != len(urls) {
+		t.Fatalf("got %d audit entries, want one per URL: %d", len(entries), len(urls))
+	}
+	for i, entry := range entries {
+		if entry.URL != urls[i] {
+			t.Errorf("entry %d: URL = %q, want %q", i, entry.URL, urls[i])
+		}
+		if entry.BatchID != resp.Header.Get(batchIDHeader) || entry.BatchID == "" {
+			t.Errorf("entry %d: BatchID = %q, want %q", i, entry.BatchID, resp.Header.Get(batchIDHeader))
+		}
+		if entry.KeyID != keyID("secret") || entry.KeyID == "secret" {
+			t.Errorf("entry %d: KeyID = %q, want the hash of the key", i, entry.KeyID)
+		}
+		if entry.ClientIP != "127.0.0.1" {
+			t.Errorf("entry %d: ClientIP = %q, want %q", i, entry.ClientIP, "127.0.0.1")
+		}
+		if entry.Time.IsZero() {
+			t.Errorf("entry %d: Time is not set", i)
+		}
+		wantStatus, wantErr := http.StatusOK, false
+		if i == 1 {
+			wantStatus, wantErr = http.StatusBadGateway, true
+		}
+		if entry.StatusCode != wantStatus || (entry.Error != "") != wantErr {
+			t.Errorf("entry %d: StatusCode = %d, Error = %q, want %d and error %t",
+				i, entry.StatusCode, entry.Error, wantStatus, wantErr)
+		}
+	}
+
+	// Requests refused over the key quota are audited too.
+	release, _ := a.acquireQuota("secret")
+	defer release()
+	if resp, _ = postURLs(t, srv, urls[:1], header); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("over quota: status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	entries = auditor.take()
+	if len(entries) != 1 || entries[0].StatusCode != 0 || entries[0].Error == "" {
+		t.Errorf("over quota: entries = %+v, want one failed entry", entries)
+	}
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// batchIDHeader carries the canonical hash of the submitted batch.
+const batchIDHeader = "X-Batch-Id"
+
+// batchID returns a hash that is the same for batches which differ only in
+// URL order or spelling. Every URL is canonicalized by canonicalURL, the
+// list is sorted and hashed with SHA-256 together with the request options
+// that change the response: the body encoding and the results format. Only
+// the map format is hashed, so that a batch asking for the default array
+// format explicitly gets the same ID as one that doesn't ask for a format.
+func batchID(urls []string, encoding string, byURL bool) string {
+	canonical := make([]string, len(urls))
+	for i, u := range urls {
+		canonical[i] = canonicalURL(u)
+	}
+	sort.Strings(canonical)
+
+	h := sha256.New()
+	h.Write([]byte("encoding=" + encoding + "\n"))
+	if byURL {
+		h.Write([]byte(formatParam + "=" + formatMapByURL + "\n"))
+	}
+	for _, u := range canonical {
+		h.Write([]byte(u + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// canonicalURL lowercases the scheme and the host, drops default ports and
+// the fragment and sorts query parameters. Unparsable URLs are kept as is.
+func canonicalURL(raw string) string {
+	uri, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return raw
+	}
+	uri.Scheme = strings.ToLower(uri.Scheme)
+	uri.Host = strings.ToLower(uri.Host)
+	if port := uri.Port(); (uri.Scheme == "http" && port == "80") || (uri.Scheme == "https" && port == "443") {
+		uri.Host = uri.Hostname()
+	}
+	uri.Fragment, uri.RawFragment = "", ""
+	uri.RawQuery = uri.Query().Encode()
+	return uri.String()
+}
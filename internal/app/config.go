@@ -0,0 +1,116 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configHandler serves the configuration the app runs with, defaults and
+// the settings passed down to the crawler included, with secrets redacted.
+func (a *app) configHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			invalidMethodErr := fmt.Errorf("method not allowed: expected %q: got %q", http.MethodGet, r.Method)
+			writeResponse(w, r, invalidMethodErr, http.StatusMethodNotAllowed)
+			log.Println("handler: config:", invalidMethodErr)
+			return
+		}
+		if !authorized(r, a.config.ConfigKey) {
+			writeResponse(w, r, errUnauthorized, http.StatusUnauthorized)
+			log.Println("handler: config:", errUnauthorized)
+			return
+		}
+		writeResponse(w, r, configValue(reflect.ValueOf(redactConfig(a.config))), http.StatusOK)
+	})
+}
+
+// redactConfig returns a copy of the config with its secrets replaced.
+// API keys of KeyQuotas are replaced by numbers, their quotas are kept,
+// the crawler's headers are redacted as they are in its logs and results,
+// and passwords are dropped from URLs.
+func redactConfig(cfg Config) Config {
+	redact := func(secret *string) {
+		if *secret != "" {
+			*secret = crawler.RedactedValue
+		}
+	}
+	redact(&cfg.SelfTestKey)
+	redact(&cfg.ConfigKey)
+
+	urls := make([]string, len(cfg.SelfTestURLs))
+	for i, u := range cfg.SelfTestURLs {
+		if uri, err := url.Parse(u); err == nil {
+			u = uri.Redacted()
+		}
+		urls[i] = u
+	}
+	cfg.SelfTestURLs = urls
+
+	if cfg.Crawler.Headers != nil {
+		names := append(append([]string(nil), crawler.DefaultRedactHeaders...), cfg.Crawler.RedactHeaders...)
+		cfg.Crawler.Headers = crawler.RedactHeaders(cfg.Crawler.Headers, names)
+	}
+
+	if cfg.Crawler.Credentials != nil {
+		credentials := make(map[string]crawler.Credential, len(cfg.Crawler.Credentials))
+		for host, credential := range cfg.Crawler.Credentials {
+			credentials[host] = credential.Redacted()
+		}
+		cfg.Crawler.Credentials = credentials
+	}
+
+	if cfg.KeyQuotas != nil {
+		quotas := make(map[string]uint16, len(cfg.KeyQuotas))
+		for _, quota := range cfg.KeyQuotas {
+			quotas[fmt.Sprintf("%s%d", crawler.RedactedValue, len(quotas)+1)] = quota
+		}
+		cfg.KeyQuotas = quotas
+	}
+	return cfg
+}
+
+// configValue converts the config to what reads well as JSON: durations
+// become strings such as "1.5s", hooks and other funcs are reported as
+// "set" or null, interfaces by the type they hold and pointers, such as
+// the TLS settings, by their type.
+func configValue(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.PkgPath == "" {
+				fields[field.Name] = configValue(v.Field(i))
+			}
+		}
+		return fields
+	case reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+		return "set"
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Type().String()
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return v.Type().String()
+	default:
+		return v.Interface()
+	}
+}
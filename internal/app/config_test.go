@@ -0,0 +1,41 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+func TestRedactConfigHeaders(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer secret")
+	headers.Set("X-Api-Key", "secret")
+	headers.Set("X-Tenant", "acme")
+	headers.Set("Accept", "application/json")
+
+	for _, names := range [][]string{nil, {}, {"X-Tenant"}} {
+		cfg := Config{Crawler: crawler.Config{Headers: headers, RedactHeaders: names}}
+		got := redactConfig(cfg).Crawler.Headers
+
+		for _, name := range []string{"Authorization", "X-Api-Key"} {
+			if v := got.Get(name); v != crawler.RedactedValue {
+				t.Errorf("RedactHeaders %q: %s = %q, want %q", names, name, v, crawler.RedactedValue)
+			}
+		}
+		if v := got.Get("Accept"); v != "application/json" {
+			t.Errorf("RedactHeaders %q: Accept = %q, want it kept", names, v)
+		}
+		wantTenant := "acme"
+		if len(names) > 0 {
+			wantTenant = crawler.RedactedValue
+		}
+		if v := got.Get("X-Tenant"); v != wantTenant {
+			t.Errorf("RedactHeaders %q: X-Tenant = %q, want %q", names, v, wantTenant)
+		}
+	}
+
+	if headers.Get("Authorization") != "Bearer secret" {
+		t.Error("redactConfig modified the configured headers")
+	}
+}
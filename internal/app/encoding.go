@@ -0,0 +1,98 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+const (
+	// bodyEncodingHeader lets clients ask for compressed result bodies.
+	bodyEncodingHeader = "X-Body-Encoding"
+	bodyEncodingGzip   = "gzip"
+)
+
+// bodyEncoding returns the encoding the client asked for result bodies,
+// an empty string means bodies are embedded as is.
+func bodyEncoding(r *http.Request) (string, error) {
+	switch encoding := r.Header.Get(bodyEncodingHeader); encoding {
+	case "", "identity":
+		return "", nil
+	case bodyEncodingGzip:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf(
+			"bad request: unsupported %q header: expected %q: got %q",
+			bodyEncodingHeader, bodyEncodingGzip, encoding)
+	}
+}
+
+// encodeBody writes the body as a JSON value: either as is, or compressed
+// with gzip and wrapped into a base64 string.
+func encodeBody(w io.Writer, body io.Reader, encoding string) error {
+	if encoding != bodyEncodingGzip {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	if _, err := io.WriteString(w, `"`); err != nil {
+		return err
+	}
+	b64 := base64.NewEncoder(base64.StdEncoding, w)
+	gz := gzip.NewWriter(b64)
+	if _, err := io.Copy(gz, body); err != nil {
+		return fmt.Errorf("compress body: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("compress body: %w", err)
+	}
+	if err := b64.Close(); err != nil {
+		return fmt.Errorf("encode body: %w", err)
+	}
+	_, err := io.WriteString(w, `"`)
+	return err
+}
+
+// encodeBase64 writes the body as a base64 string as it is.
+func encodeBase64(w io.Writer, body io.Reader) error {
+	if _, err := io.WriteString(w, `"`); err != nil {
+		return err
+	}
+	b64 := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := io.Copy(b64, body); err != nil {
+		return err
+	}
+	if err := b64.Close(); err != nil {
+		return fmt.Errorf("encode body: %w", err)
+	}
+	_, err := io.WriteString(w, `"`)
+	return err
+}
+
+// encodeResultBody writes the body of the result and returns the encoding to
+// flag it with. Raw bodies are sent in the encoding of the upstream as base64
+// strings, the rest the way the client asked for.
+func encodeResultBody(w io.Writer, res crawler.Result, body io.Reader, encoding string) (string, error) {
+	if res.ContentEncoding == "" {
+		return encoding, encodeBody(w, body, encoding)
+	}
+	return res.ContentEncoding, encodeBase64(w, body)
+}
+
+// encodeResultBytes is encodeResultBody for bodies held in memory.
+func encodeResultBytes(res crawler.Result, encoding string) ([]byte, string, error) {
+	if res.ResponseBody == nil || (res.ContentEncoding == "" && encoding != bodyEncodingGzip) {
+		return res.ResponseBody, encoding, nil
+	}
+	buf := new(bytes.Buffer)
+	encoding, err := encodeResultBody(buf, res, bytes.NewReader(res.ResponseBody), encoding)
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), encoding, nil
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestGzipBodyRoundTrip(t *testing.T) {
+	const body = `{"a":[1,2,3],"b":"text"}`
+	upstream := newUpstream(t, body)
+
+	for _, spill := range []bool{false, true} {
+		_, srv := newTestApp(t, Config{SpillResults: spill})
+		header := http.Header{bodyEncodingHeader: {bodyEncodingGzip}}
+		resp, data := postURLs(t, srv, []string{upstream.URL}, header)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("spill %t: status = %d, want %d: %s", spill, resp.StatusCode, http.StatusOK, data)
+		}
+
+		var decoded struct {
+			Results []urlsResult `json:"results"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("spill %t: decode %q: %v", spill, data, err)
+		}
+		res := decoded.Results[0].Response
+		if res.Encoding != bodyEncodingGzip {
+			t.Errorf("spill %t: encoding = %q, want %q", spill, res.Encoding, bodyEncodingGzip)
+		}
+
+		var encoded string
+		if err := json.Unmarshal(res.ResponseBody, &encoded); err != nil {
+			t.Fatalf("spill %t: body is not a string: %s", spill, res.ResponseBody)
+		}
+		compressed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("spill %t: decode base64: %v", spill, err)
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("spill %t: open gzip: %v", spill, err)
+		}
+		decompressed, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatalf("spill %t: decompress: %v", spill, err)
+		}
+		if string(decompressed) != body {
+			t.Errorf("spill %t: body = %s, want %s", spill, decompressed, body)
+		}
+	}
+}
+
+func TestBodyEncodingHeader(t *testing.T) {
+	for value, wantErr := range map[string]bool{"": false, "identity": false, "gzip": false, "br": true} {
+		r, _ := http.NewRequest(http.MethodPost, "/crawler", nil)
+		r.Header.Set(bodyEncodingHeader, value)
+		if _, err := bodyEncoding(r); (err != nil) != wantErr {
+			t.Errorf("bodyEncoding(%q) error = %v, want error %t", value, err, wantErr)
+		}
+	}
+}
@@ -6,123 +6,343 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
 )
 
 const (
-	maxURLsNumber     = 20
+	defaultMaxURLs    = 20
 	contentTypeHeader = "Content-Type"
 	contentTypeJSON   = "application/json"
+
+	// formatParam switches results from an array to an object keyed by URL.
+	formatParam    = "format"
+	formatArray    = "array"
+	formatMapByURL = "map"
 )
 
 type (
 	urlsRequest struct {
-		URLs []string `json:"urls"`
+		URLs       []string
+		Priorities []int // Set if the client passed URL entries as objects.
+	}
+	urlEntry struct {
+		URL      string `json:"url"`
+		Priority int    `json:"priority"`
+	}
+	errorResponse struct {
+		Error string `json:"error"`
 	}
 	urlsResult struct {
-		SourceURL string `json:"url"`
-		Response  struct {
-			StatusCode   int             `json:"code"`
-			ResponseBody json.RawMessage `json:"body"`
-		} `json:"response"`
+		SourceURL string      `json:"url"`
+		Response  urlResponse `json:"response"`
+	}
+	urlResponse struct {
+		StatusCode   int             `json:"code"`
+		ResponseBody json.RawMessage `json:"body"`
+		Encoding     string          `json:"encoding,omitempty"`
+		Truncated    bool            `json:"truncated,omitempty"`
+		Error        string          `json:"error,omitempty"` // Set on failed URLs with Crawler.ContinueOnError.
 	}
 )
 
+// maxURLs returns the number of URLs a request may have.
+func (a *app) maxURLs() int {
+	if a.config.MaxURLs > 0 {
+		return int(a.config.MaxURLs)
+	}
+	return defaultMaxURLs
+}
+
 func (a *app) handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Given condition: POST-method.
-		if r.Method != http.MethodPost {
-			invalidMethodErr := fmt.Errorf("method not allowed: expected %q: got %q", http.MethodPost, r.Method)
-			writeResponse(w, invalidMethodErr, http.StatusMethodNotAllowed)
-			log.Println("handler:", invalidMethodErr)
-			return
-		}
-
-		// Given condition: JSON input.
-		givenContentType := r.Header.Get(contentTypeHeader)
-		if givenContentType != contentTypeJSON {
-			invalidContentTypeErr := fmt.Errorf(
-				`unsupported %q header: expected %q: got %q`,
-				contentTypeHeader, contentTypeJSON, givenContentType)
-			writeResponse(w, invalidContentTypeErr, http.StatusUnsupportedMediaType)
-			log.Println("handler:", invalidContentTypeErr)
-			return
-		}
-
-		if r.ContentLength == 0 {
-			emptyContentErr := errors.New("bad request: empty request body")
-			writeResponse(w, emptyContentErr, http.StatusBadRequest)
-			log.Println("handler:", emptyContentErr)
+		// Draining instances don't take new work.
+		if a.isLameDuck() {
+			setRetryAfter(w, a.lameDuckRetryAfter())
+			writeResponse(w, r, errLameDuck, http.StatusServiceUnavailable)
+			log.Println("handler:", errLameDuck)
 			return
 		}
 
+		// Given condition: POST-method. GET takes the URLs from the query
+		// for quick checks by hand.
 		var jsonReq urlsRequest
-		if err := json.NewDecoder(r.Body).Decode(&jsonReq); err != nil {
-			var jsonErr error
-			if ute, ok := err.(*json.UnmarshalTypeError); ok {
-				jsonErr = fmt.Errorf("bad request: invalid type for %s: %v", ute.Value, ute.Type)
-			} else {
-				jsonErr = fmt.Errorf("bad request: %s", err.Error())
+		switch r.Method {
+		case http.MethodPost:
+			// Given condition: JSON input, or a file with URLs uploaded via a form.
+			var code int
+			var err error
+			if jsonReq, code, err = a.decodeRequest(w, r); err != nil {
+				writeResponse(w, r, err, code)
+				log.Println("handler:", err)
+				return
 			}
-			writeResponse(w, jsonErr, http.StatusBadRequest)
-			log.Println("handler:", jsonErr)
+		case http.MethodGet:
+			jsonReq = queryRequest(r)
+		default:
+			invalidMethodErr := fmt.Errorf("method not allowed: expected %q or %q: got %q",
+				http.MethodPost, http.MethodGet, r.Method)
+			writeResponse(w, r, invalidMethodErr, http.StatusMethodNotAllowed)
+			log.Println("handler:", invalidMethodErr)
 			return
 		}
 
 		// Given condition: limited number of URLs. Handle edge cases.
 		if len(jsonReq.URLs) == 0 {
 			noURLsErr := errors.New("bad request: no URLs passed")
-			writeResponse(w, noURLsErr, http.StatusBadRequest)
+			writeResponse(w, r, noURLsErr, http.StatusBadRequest)
 			log.Println("handler:", noURLsErr)
 			return
 		}
-		if len(jsonReq.URLs) > maxURLsNumber {
+		if maxURLs := a.maxURLs(); len(jsonReq.URLs) > maxURLs {
 			maxURLsNumberErr := fmt.Errorf(
 				"max number of URLs exceeded: %d of %d",
-				len(jsonReq.URLs), maxURLsNumber)
-			writeResponse(w, maxURLsNumberErr, http.StatusBadRequest)
+				len(jsonReq.URLs), maxURLs)
+			writeResponse(w, r, maxURLsNumberErr, http.StatusBadRequest)
 			log.Println("handler:", maxURLsNumberErr)
 			return
 		}
 
+		if a.config.RejectDuplicateURLs {
+			if duplicates := duplicateURLs(jsonReq.URLs); len(duplicates) > 0 {
+				duplicatesErr := fmt.Errorf("bad request: duplicate URLs passed: %q", duplicates)
+				writeResponse(w, r, duplicatesErr, http.StatusBadRequest)
+				log.Println("handler:", duplicatesErr)
+				return
+			}
+		}
+
+		if limit := a.config.MaxDistinctHosts; limit > 0 {
+			if hosts := distinctHosts(jsonReq.URLs); hosts > int(limit) {
+				maxHostsErr := fmt.Errorf("bad request: max number of distinct hosts exceeded: %d of %d", hosts, limit)
+				writeResponse(w, r, maxHostsErr, http.StatusBadRequest)
+				log.Println("handler:", maxHostsErr)
+				return
+			}
+		}
+
+		encoding, err := bodyEncoding(r)
+		if err != nil {
+			writeResponse(w, r, err, http.StatusBadRequest)
+			log.Println("handler:", err)
+			return
+		}
+
+		byURL, err := resultsByURL(r)
+		if err != nil {
+			writeResponse(w, r, err, http.StatusBadRequest)
+			log.Println("handler:", err)
+			return
+		}
+
+		// Let clients recognize resubmissions of the same batch.
+		batch := batchID(jsonReq.URLs, encoding, byURL)
+		w.Header().Set(batchIDHeader, batch)
+
+		// Don't let a single tenant take up the whole outgoing requests budget.
+		apiKey := r.Header.Get(apiKeyHeader)
+		release, ok := a.acquireQuota(apiKey)
+		if !ok {
+			quotaErr := fmt.Errorf(
+				"too many requests: quota of %d simultaneous crawls per API key exceeded",
+				a.keyQuota(apiKey))
+			a.audit(r, batch, jsonReq.URLs, nil, quotaErr)
+			setRetryAfter(w, a.quotaRetryAfter())
+			writeResponse(w, r, quotaErr, http.StatusTooManyRequests)
+			log.Println("handler:", quotaErr)
+			return
+		}
+		defer release()
+
 		// Given condition: get data from URLs or return first error.
-		results, err := a.crawler.Crawl(r.Context(), jsonReq.URLs)
+		start := a.clock.Now()
+		results, err := a.crawl(r.Context(), jsonReq, a.idempotencyKey(r))
+		a.recordCrawlTime(a.clock.Now().Sub(start))
+		a.audit(r, batch, jsonReq.URLs, results, err)
 		if err != nil {
-			writeResponse(w, err, http.StatusInternalServerError)
+			// Partial results come along with some errors, nobody reads them.
+			cleanupResults(results)
+			code := http.StatusInternalServerError
+			if errors.Is(err, crawler.ErrHostNotAllowed) || errors.Is(err, crawler.ErrPrivateNetwork) {
+				code = http.StatusForbidden
+			}
+			writeResponse(w, r, err, code)
 			log.Println("handler:", err)
 			return
 		}
 
+		if a.config.SpillResults {
+			a.writeSpilledResponse(w, r, results, encoding, byURL)
+			return
+		}
+
 		response := make([]urlsResult, len(results))
 		for i, res := range results {
+			body, resEncoding, err := encodeResultBytes(res, encoding)
+			if err != nil {
+				writeResponse(w, r, err, http.StatusInternalServerError)
+				log.Println("handler:", err)
+				return
+			}
 			response[i].SourceURL = res.SourceURL
 			response[i].Response.StatusCode = res.StatusCode
-			response[i].Response.ResponseBody = res.ResponseBody
+			response[i].Response.ResponseBody = body
+			response[i].Response.Truncated = res.Truncated
+			if err := res.Err(); err != nil {
+				response[i].Response.Error = err.Error()
+			}
+			if body != nil {
+				response[i].Response.Encoding = resEncoding
+			}
+		}
+
+		if byURL {
+			// Of duplicate URLs the last result wins.
+			responseByURL := make(map[string]urlResponse, len(response))
+			for _, res := range response {
+				responseByURL[res.SourceURL] = res.Response
+			}
+			writeResponse(w, r, responseByURL, http.StatusOK)
+			return
 		}
 
-		writeResponse(w, response, http.StatusOK)
+		writeResponse(w, r, response, http.StatusOK)
 		return
 	})
 }
 
-func writeResponse(w http.ResponseWriter, data interface{}, httpStatusCode int) {
-	w.Header().Set(contentTypeHeader, contentTypeJSON)
-	w.WriteHeader(httpStatusCode)
+// UnmarshalJSON decodes URLs passed either as plain strings or as objects with a priority.
+func (req *urlsRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		URLs []json.RawMessage `json:"urls"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
 
-	if err, isErr := data.(error); isErr {
-		if _, err = w.Write([]byte(err.Error())); err != nil {
-			log.Println("response: write data to buffer:", err)
+	req.URLs = make([]string, len(raw.URLs))
+	for i, item := range raw.URLs {
+		if len(item) > 0 && item[0] == '{' {
+			var entry urlEntry
+			if err := json.Unmarshal(item, &entry); err != nil {
+				return err
+			}
+			if req.Priorities == nil {
+				req.Priorities = make([]int, len(raw.URLs))
+			}
+			req.URLs[i], req.Priorities[i] = entry.URL, entry.Priority
+			continue
 		}
+		if err := json.Unmarshal(item, &req.URLs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prioritized pairs the URLs with their priorities.
+func (req urlsRequest) prioritized() []crawler.PriorityURL {
+	urls := make([]crawler.PriorityURL, len(req.URLs))
+	for i := range req.URLs {
+		urls[i] = crawler.PriorityURL{URL: req.URLs[i], Priority: req.Priorities[i]}
+	}
+	return urls
+}
+
+// resultsByURL reports whether the client asked for results keyed by URL.
+func resultsByURL(r *http.Request) (bool, error) {
+	switch format := r.URL.Query().Get(formatParam); format {
+	case "", formatArray:
+		return false, nil
+	case formatMapByURL:
+		return true, nil
+	default:
+		return false, fmt.Errorf(
+			"bad request: unsupported %q parameter: expected %q or %q: got %q",
+			formatParam, formatArray, formatMapByURL, format)
+	}
+}
+
+// lastPerURL drops all but the last result of every URL.
+func lastPerURL(results []crawler.Result) []crawler.Result {
+	last := make(map[string]int, len(results))
+	for i, res := range results {
+		last[res.SourceURL] = i
+	}
+
+	unique := make([]crawler.Result, 0, len(last))
+	for i, res := range results {
+		if last[res.SourceURL] == i {
+			unique = append(unique, res)
+		}
+	}
+	return unique
+}
+
+// duplicateURLs returns URLs that occur more than once, each of them listed once.
+func duplicateURLs(urls []string) (duplicates []string) {
+	seen := make(map[string]int, len(urls))
+	for _, u := range urls {
+		if seen[u]++; seen[u] == 2 {
+			duplicates = append(duplicates, u)
+		}
+	}
+	return duplicates
+}
+
+// distinctHosts returns the number of different hosts the URLs point to,
+// ignoring ports and case. URLs without a host are left for the crawler to reject.
+func distinctHosts(urls []string) int {
+	hosts := make(map[string]struct{}, len(urls))
+	for _, u := range urls {
+		uri, err := url.Parse(strings.TrimSpace(u))
+		if err != nil || uri.Hostname() == "" {
+			continue
+		}
+		hosts[strings.ToLower(uri.Hostname())] = struct{}{}
+	}
+	return len(hosts)
+}
+
+// writeResponse sends the data wrapped into a JSON object. Errors are sent
+// either as a JSON object or as plain text, whichever the client accepts.
+func writeResponse(w http.ResponseWriter, r *http.Request, data interface{}, httpStatusCode int) {
+	if err, isErr := data.(error); isErr {
+		writeError(w, r, err, httpStatusCode)
 		return
 	}
 
 	resp := make(map[string]interface{})
 	resp["results"] = data
 
+	// Marshal before sending the status, so a failure can still be reported.
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
 		log.Println("response: marshal to json:", err)
+		writeError(w, r, fmt.Errorf("internal server error: marshal response: %w", err), http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set(contentTypeHeader, contentTypeJSON)
+	w.WriteHeader(httpStatusCode)
 	if _, err = w.Write(jsonResp); err != nil {
 		log.Println("response: write data to buffer:", err)
 	}
 }
+
+// writeError sends the error message with the content type it is encoded in.
+func writeError(w http.ResponseWriter, r *http.Request, err error, httpStatusCode int) {
+	contentType, body := contentTypeText, []byte(err.Error())
+	if !prefersText(r) {
+		contentType = contentTypeJSON
+		body, _ = json.Marshal(errorResponse{Error: err.Error()})
+	}
+
+	w.Header().Set(contentTypeHeader, contentType)
+	w.WriteHeader(httpStatusCode)
+	if _, err = w.Write(body); err != nil {
+		log.Println("response: write data to buffer:", err)
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteResponseMarshalFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/crawler", nil)
+
+	// Channels can't be marshaled to JSON.
+	writeResponse(w, r, map[string]interface{}{"body": make(chan int)}, http.StatusOK)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got := w.Header().Get(contentTypeHeader); got != contentTypeJSON {
+		t.Errorf("%s = %q, want %q", contentTypeHeader, got, contentTypeJSON)
+	}
+	var resp errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	if resp.Error == "" {
+		t.Error("error message is empty")
+	}
+}
+
+func TestDuplicateURLs(t *testing.T) {
+	tests := []struct {
+		urls []string
+		want []string
+	}{
+		{urls: []string{"a", "b", "c"}},
+		{urls: []string{"a", "b", "a"}, want: []string{"a"}},
+		{urls: []string{"a", "a", "a", "b", "b"}, want: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if got := duplicateURLs(tt.urls); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("duplicateURLs(%q) = %q, want %q", tt.urls, got, tt.want)
+		}
+	}
+}
+
+func TestRejectDuplicateURLs(t *testing.T) {
+	upstream := newUpstream(t, `{"a":1}`)
+	urls := []string{upstream.URL + "/a", upstream.URL + "/b", upstream.URL + "/a"}
+
+	t.Run("rejected", func(t *testing.T) {
+		_, srv := newTestApp(t, Config{RejectDuplicateURLs: true})
+		resp, body := postURLs(t, srv, urls, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+		}
+		if !strings.Contains(string(body), upstream.URL+"/a") || strings.Contains(string(body), upstream.URL+"/b") {
+			t.Errorf("body = %s, want only the duplicate URL listed", body)
+		}
+	})
+	t.Run("allowed", func(t *testing.T) {
+		_, srv := newTestApp(t, Config{})
+		resp, body := postURLs(t, srv, urls, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+		}
+		var decoded struct {
+			Results []urlsResult `json:"results"`
+		}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded.Results) != len(urls) {
+			t.Errorf("got %d results, want one per URL: %d", len(decoded.Results), len(urls))
+		}
+	})
+}
+
+func TestDistinctHosts(t *testing.T) {
+	urls := []string{
+		"http://a.test/1", "https://A.test:8443/2", "http://b.test", " http://c.test/ ", "not a url", "",
+	}
+	if got := distinctHosts(urls); got != 3 {
+		t.Errorf("distinctHosts() = %d, want 3", got)
+	}
+}
+
+func TestMaxDistinctHosts(t *testing.T) {
+	upstream := newUpstream(t, `{}`)
+	_, srv := newTestApp(t, Config{MaxDistinctHosts: 1})
+
+	resp, body := postURLs(t, srv, []string{upstream.URL + "/a", upstream.URL + "/b"}, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("at the limit: status = %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+	}
+
+	resp, body = postURLs(t, srv, []string{upstream.URL, "http://other.test"}, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("over the limit: status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if !strings.Contains(string(body), "2 of 1") {
+		t.Errorf("over the limit: body = %s, want the count of hosts", body)
+	}
+}
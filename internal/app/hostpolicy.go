@@ -0,0 +1,54 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+// hostPolicyFile is the format of HostPolicyFile.
+type hostPolicyFile struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// loadHostPolicy reads the host policy from HostPolicyFile and hands it to
+// the crawler. Crawls in flight finish with the policy they started with.
+func (a *app) loadHostPolicy() error {
+	data, err := ioutil.ReadFile(a.config.HostPolicyFile)
+	if err != nil {
+		return fmt.Errorf("read host policy: %w", err)
+	}
+	var file hostPolicyFile
+	if err = json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse host policy: %w", err)
+	}
+	a.crawler.UpdateHostPolicy(crawler.HostPolicy{Allow: file.Allow, Deny: file.Deny})
+	return nil
+}
+
+// watchHostPolicy reloads the host policy on every signal. A policy that
+// fails to load is logged and the previous one stays in force.
+func (a *app) watchHostPolicy(stop <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, reloadSignals...)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case sig := <-ch:
+			if err := a.loadHostPolicy(); err != nil {
+				log.Printf("app: OS signal received: %s: keeping host policy: %s\n", sig.String(), err)
+				continue
+			}
+			log.Printf("app: OS signal received: %s: host policy reloaded\n", sig.String())
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadHostPolicy(t *testing.T) {
+	upstream := newUpstream(t, `{}`)
+	file := filepath.Join(t.TempDir(), "policy.json")
+	write := func(data string) {
+		if err := ioutil.WriteFile(file, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"allow": ["127.0.0.1"]}`)
+	a, srv := newTestApp(t, Config{HostPolicyFile: file})
+	if resp, body := postURLs(t, srv, []string{upstream.URL}, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("allowed: status = %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+	}
+
+	write(`{"deny": ["127.0.0.1"]}`)
+	if err := a.loadHostPolicy(); err != nil {
+		t.Fatalf("loadHostPolicy() error = %v", err)
+	}
+	if resp, _ := postURLs(t, srv, []string{upstream.URL}, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("denied: status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	// A broken file leaves the policy in force.
+	write(`{"allow":`)
+	if err := a.loadHostPolicy(); err == nil {
+		t.Error("loadHostPolicy() error = nil, want a parse error")
+	}
+	if resp, _ := postURLs(t, srv, []string{upstream.URL}, nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("after a broken reload: status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+const (
+	// idempotencyKeyHeader lets clients retry a batch without crawling
+	// the URLs that already succeeded.
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// defaultIdempotencyMaxKeys bounds the store unless IdempotencyMaxKeys is set.
+	defaultIdempotencyMaxKeys = 1000
+)
+
+type (
+	// idempotency stores successful results per idempotency key.
+	idempotency struct {
+		sync.Mutex
+		entries map[string]map[string]cachedResult
+	}
+	cachedResult struct {
+		crawler.Result
+		expires time.Time
+	}
+)
+
+// idempotencyKey returns the store key of the request, empty if the request
+// doesn't carry one or the store is disabled. Keys are scoped by API key, so
+// that tenants can't read each other's results by guessing keys.
+func (a *app) idempotencyKey(r *http.Request) string {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" || a.config.IdempotencyTTL <= 0 {
+		return ""
+	}
+	return r.Header.Get(apiKeyHeader) + "\x00" + key
+}
+
+// crawl crawls the URLs of the request. With an idempotency key, URLs that
+// succeeded under the same key before are taken from the store and only the
+// rest are crawled. Results are returned in the order of the request either way.
+func (a *app) crawl(ctx context.Context, req urlsRequest, key string) ([]crawler.Result, error) {
+	cached := a.idempotency.lookup(key, a.clock.Now())
+
+	pending := req
+	if len(cached) > 0 {
+		pending = req.without(cached)
+	}
+
+	var results []crawler.Result
+	var err error
+	switch {
+	case len(pending.URLs) == 0:
+	case pending.Priorities == nil:
+		results, err = a.crawler.Crawl(ctx, pending.URLs)
+	default:
+		results, err = a.crawler.CrawlPrioritized(ctx, pending.prioritized())
+	}
+
+	// With ContinueOnError failed URLs are among the results, they don't fail the batch.
+	var failed crawler.CrawlErrors
+	if errors.As(err, &failed) {
+		err = nil
+	}
+
+	// Partial results come along with some errors, keep them for the retry.
+	if key != "" {
+		a.idempotency.store(key, results, a.clock.Now(), a.config.IdempotencyTTL, a.idempotencyMaxKeys())
+	}
+	if err != nil || len(cached) == 0 {
+		return results, err
+	}
+
+	// Fresh results come in the order of the pending URLs, some of them may
+	// be missing though, so they are matched to the request by URL.
+	fresh := make(map[string][]crawler.Result, len(results))
+	for _, res := range results {
+		fresh[res.SourceURL] = append(fresh[res.SourceURL], res)
+	}
+	merged := make([]crawler.Result, 0, len(req.URLs))
+	for _, u := range req.URLs {
+		if res, ok := cached[u]; ok {
+			merged = append(merged, res)
+			continue
+		}
+		if queued := fresh[u]; len(queued) > 0 {
+			merged = append(merged, queued[0])
+			fresh[u] = queued[1:]
+		}
+	}
+	return merged, nil
+}
+
+// idempotencyMaxKeys returns the number of keys the store holds at most.
+func (a *app) idempotencyMaxKeys() int {
+	if a.config.IdempotencyMaxKeys > 0 {
+		return a.config.IdempotencyMaxKeys
+	}
+	return defaultIdempotencyMaxKeys
+}
+
+// without returns the request with the given URLs left out.
+func (req urlsRequest) without(done map[string]crawler.Result) urlsRequest {
+	var pending urlsRequest
+	for i, u := range req.URLs {
+		if _, ok := done[u]; ok {
+			continue
+		}
+		pending.URLs = append(pending.URLs, u)
+		if req.Priorities != nil {
+			pending.Priorities = append(pending.Priorities, req.Priorities[i])
+		}
+	}
+	return pending
+}
+
+// lookup returns the unexpired results stored for the key by URL.
+func (s *idempotency) lookup(key string, now time.Time) map[string]crawler.Result {
+	if key == "" {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	results := make(map[string]crawler.Result, len(s.entries[key]))
+	for u, res := range s.entries[key] {
+		if now.Before(res.expires) {
+			results[u] = res.Result
+		}
+	}
+	return results
+}
+
+// store keeps the successful results for the key for ttl. Spilled results
+// aren't kept, their files are removed once the response is sent. Expired results are
+// dropped on the way; if the store is full, the key whose results expire
+// first is evicted to make room for a new one.
+func (s *idempotency) store(key string, results []crawler.Result, now time.Time, ttl time.Duration, maxKeys int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sweep(now)
+
+	expires := now.Add(ttl)
+	for _, res := range results {
+		if res.BodyPath != "" || res.Err() != nil {
+			continue
+		}
+		entry, ok := s.entries[key]
+		if !ok {
+			if s.entries == nil {
+				s.entries = make(map[string]map[string]cachedResult)
+			}
+			if len(s.entries) >= maxKeys {
+				s.evict()
+			}
+			entry = make(map[string]cachedResult)
+			s.entries[key] = entry
+		}
+		entry[res.SourceURL] = cachedResult{Result: res, expires: expires}
+	}
+}
+
+// sweep drops the results that have expired by now.
+func (s *idempotency) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		for u, res := range entry {
+			if !now.Before(res.expires) {
+				delete(entry, u)
+			}
+		}
+		if len(entry) == 0 {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// evict drops the key whose last result expires first.
+func (s *idempotency) evict() {
+	var oldest string
+	var oldestExpires time.Time
+	for key, entry := range s.entries {
+		var expires time.Time
+		for _, res := range entry {
+			if res.expires.After(expires) {
+				expires = res.expires
+			}
+		}
+		if oldest == "" || expires.Before(oldestExpires) {
+			oldest, oldestExpires = key, expires
+		}
+	}
+	delete(s.entries, oldest)
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+// flakyUpstream fails the first request to /bad and counts requests by path.
+type flakyUpstream struct {
+	sync.Mutex
+	hits map[string]int
+}
+
+func (u *flakyUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.Lock()
+	u.hits[r.URL.Path]++
+	hits := u.hits[r.URL.Path]
+	u.Unlock()
+
+	if r.URL.Path == "/bad" && hits == 1 {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+}
+
+func (u *flakyUpstream) count(path string) int {
+	u.Lock()
+	defer u.Unlock()
+	return u.hits[path]
+}
+
+func TestCrawlResumesPartiallyFailedBatch(t *testing.T) {
+	upstream := &flakyUpstream{hits: make(map[string]int)}
+	srv := httptest.NewServer(upstream)
+	defer srv.Close()
+
+	fake := clock.NewFake(time.Unix(0, 0))
+	cr, err := crawler.NewWithConfig(crawler.Config{ContinueOnError: true, LogLevel: crawler.LogLevelSilent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{
+		config:  Config{IdempotencyTTL: time.Minute},
+		clock:   fake,
+		crawler: cr,
+	}
+
+	req := urlsRequest{URLs: []string{srv.URL + "/a", srv.URL + "/bad", srv.URL + "/c"}}
+	const key = "key"
+
+	results, err := a.crawl(context.Background(), req, key)
+	if err != nil {
+		t.Fatalf("first crawl: error = %v", err)
+	}
+	if results[1].Err() == nil {
+		t.Fatal("first crawl: /bad succeeded, want it failed")
+	}
+
+	results, err = a.crawl(context.Background(), req, key)
+	if err != nil {
+		t.Fatalf("retry: error = %v", err)
+	}
+	if len(results) != len(req.URLs) {
+		t.Fatalf("retry: got %d results, want %d", len(results), len(req.URLs))
+	}
+	for i, res := range results {
+		if res.SourceURL != req.URLs[i] {
+			t.Errorf("retry: result %d is of %s, want %s", i, res.SourceURL, req.URLs[i])
+		}
+		if res.Err() != nil {
+			t.Errorf("retry: result %d error = %v", i, res.Err())
+		}
+	}
+	for path, want := range map[string]int{"/a": 1, "/bad": 2, "/c": 1} {
+		if got := upstream.count(path); got != want {
+			t.Errorf("retry: %s crawled %d times, want %d", path, got, want)
+		}
+	}
+
+	// Once the stored results expire everything is crawled again.
+	fake.Advance(time.Minute)
+	if _, err := a.crawl(context.Background(), req, key); err != nil {
+		t.Fatalf("after TTL: error = %v", err)
+	}
+	if got := upstream.count("/a"); got != 2 {
+		t.Errorf("after TTL: /a crawled %d times, want 2", got)
+	}
+}
+
+func TestCrawlWithoutKeyCrawlsEverything(t *testing.T) {
+	upstream := &flakyUpstream{hits: make(map[string]int)}
+	srv := httptest.NewServer(upstream)
+	defer srv.Close()
+
+	cr, err := crawler.NewWithConfig(crawler.Config{ContinueOnError: true, LogLevel: crawler.LogLevelSilent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{config: Config{IdempotencyTTL: time.Minute}, clock: clock.New(), crawler: cr}
+
+	req := urlsRequest{URLs: []string{srv.URL + "/a"}}
+	for i := 0; i < 2; i++ {
+		if _, err := a.crawl(context.Background(), req, ""); err != nil {
+			t.Fatalf("crawl %d: error = %v", i, err)
+		}
+	}
+	if got := upstream.count("/a"); got != 2 {
+		t.Errorf("/a crawled %d times, want 2", got)
+	}
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// activity keeps track of incoming requests to detect an idle server.
+type activity struct {
+	lastSeen int64 // Unix time in nanoseconds of the last request.
+	inFlight int64 // Number of requests being served right now.
+}
+
+// trackActivity wraps the handler to record the time of every incoming request.
+func (a *app) trackActivity(h http.Handler) http.Handler {
+	atomic.StoreInt64(&a.activity.lastSeen, a.clock.Now().UnixNano())
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&a.activity.inFlight, 1)
+		defer func() {
+			atomic.StoreInt64(&a.activity.lastSeen, a.clock.Now().UnixNano())
+			atomic.AddInt64(&a.activity.inFlight, -1)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// watchIdle triggers the closer once no requests were served for IdleShutdown.
+// Requests that are still in flight keep the server alive.
+func (a *app) watchIdle(stop <-chan struct{}) {
+	timeout := a.config.IdleShutdown
+	timer := a.clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C():
+			idle := a.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&a.activity.lastSeen)))
+			if atomic.LoadInt64(&a.activity.inFlight) == 0 && idle >= timeout {
+				log.Printf("http: idle for %.2fs: shutting down\n", idle.Seconds())
+				a.closer.Close()
+				return
+			}
+			if idle >= timeout {
+				idle = 0
+			}
+			timer.Reset(timeout - idle)
+		}
+	}
+}
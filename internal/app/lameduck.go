@@ -0,0 +1,58 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// errLameDuck is returned to new requests once the app is being drained.
+var errLameDuck = errors.New("service unavailable: instance is draining")
+
+// isLameDuck reports whether the app stopped taking new work.
+func (a *app) isLameDuck() bool {
+	return atomic.LoadInt32(&a.lameDuck) == 1
+}
+
+// watchLameDuck switches the app to lame-duck mode on the first signal:
+// readiness fails and new crawl requests are refused, while in-flight ones
+// are served as usual. The next signal triggers the full shutdown.
+func (a *app) watchLameDuck(stop <-chan struct{}) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, lameDuckSignals...)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case sig := <-ch:
+			if atomic.CompareAndSwapInt32(&a.lameDuck, 0, 1) {
+				log.Printf("OS signal received: %s: entering lame-duck mode\n", sig.String())
+				continue
+			}
+			log.Printf("OS signal received: %s: leaving lame-duck mode for shutdown\n", sig.String())
+			a.closer.Close()
+			return
+		}
+	}
+}
+
+// readinessHandler tells load balancers whether to route traffic to the app.
+func (a *app) readinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isLameDuck() {
+			setRetryAfter(w, a.lameDuckRetryAfter())
+			writeResponse(w, r, errLameDuck, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set(contentTypeHeader, contentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte(`{"status":"ready"}`)); err != nil {
+			log.Println("response: write data to buffer:", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+// freePort returns a TCP port that nothing listens on at the moment.
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestReadinessWhileSaturated(t *testing.T) {
+	instance, err := NewWithConfig(Config{
+		MaxConnections:  1,
+		MonitoringPort:  freePort(t),
+		GracefulTimeout: time.Second,
+		Crawler:         crawler.Config{LogLevel: crawler.LogLevelSilent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := instance.(*app)
+	stopped := make(chan error, 1)
+	go func() { stopped <- a.Run() }()
+	defer func() {
+		a.closer.Close()
+		<-stopped
+	}()
+
+	port := func(l net.Listener) string {
+		return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	}
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+
+	// An idle client holds the only connection of the public port.
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port(a.http.listener))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if resp, err := client.Get("http://127.0.0.1:" + port(a.http.listener) + "/readiness"); err == nil {
+		_ = resp.Body.Close()
+		t.Fatalf("public port answered with %d, want the connection limit to hold the probe", resp.StatusCode)
+	}
+
+	resp, err := client.Get("http://127.0.0.1:" + port(a.http.monitoring) + "/readiness")
+	if err != nil {
+		t.Fatalf("probe of the monitoring port: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("monitoring port: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"sync"
+)
+
+// apiKeyHeader identifies the tenant a crawl request belongs to.
+const apiKeyHeader = "X-Api-Key"
+
+// quotas counts active crawls per API key.
+type quotas struct {
+	sync.Mutex
+	active map[string]uint16
+}
+
+// keyQuota returns the number of simultaneous crawls allowed for the key, zero means unlimited.
+func (a *app) keyQuota(key string) uint16 {
+	if quota, ok := a.config.KeyQuotas[key]; ok {
+		return quota
+	}
+	return a.config.DefaultKeyQuota
+}
+
+// acquireQuota books a crawl for the key. It returns false if the key has
+// reached its quota, otherwise the returned func must be called once the
+// crawl is over.
+func (a *app) acquireQuota(key string) (release func(), ok bool) {
+	quota := a.keyQuota(key)
+	if quota == 0 {
+		return func() {}, true
+	}
+
+	a.quotas.Lock()
+	defer a.quotas.Unlock()
+
+	if a.quotas.active == nil {
+		a.quotas.active = make(map[string]uint16)
+	}
+	if a.quotas.active[key] >= quota {
+		return nil, false
+	}
+	a.quotas.active[key]++
+
+	return func() {
+		a.quotas.Lock()
+		defer a.quotas.Unlock()
+
+		// Forget idle keys so that random ones don't pile up.
+		if a.quotas.active[key]--; a.quotas.active[key] == 0 {
+			delete(a.quotas.active, key)
+		}
+	}, true
+}
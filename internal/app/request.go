@@ -0,0 +1,121 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	contentTypeMultipart = "multipart/form-data"
+	contentTypeForm      = "application/x-www-form-urlencoded"
+	uploadField          = "urls"  // Form field with the uploaded URLs file, or a URL of a form-encoded body.
+	maxUploadSize        = 1 << 20 // Max size of an uploaded URLs file.
+	urlParam             = "url"   // Query parameter with a URL of GET requests, repeated for every URL.
+)
+
+// queryRequest reads the URLs list of a GET request from its query.
+func queryRequest(r *http.Request) urlsRequest {
+	return urlsRequest{URLs: r.URL.Query()[urlParam]}
+}
+
+// decodeRequest reads the URLs list either from a JSON body, a form-encoded
+// one or from a file uploaded as multipart/form-data. The returned status
+// code describes the error.
+func (a *app) decodeRequest(w http.ResponseWriter, r *http.Request) (urlsRequest, int, error) {
+	givenContentType := r.Header.Get(contentTypeHeader)
+	mediaType, _, _ := mime.ParseMediaType(givenContentType)
+	switch mediaType {
+	case contentTypeMultipart:
+		return decodeUpload(w, r)
+	case contentTypeForm:
+		return decodeForm(w, r)
+	}
+
+	// Given condition: JSON input. Bodies without a content type may be
+	// taken for JSON, a different content type is never.
+	missingContentType := givenContentType == "" && a.config.AcceptMissingContentType
+	if mediaType != contentTypeJSON && !missingContentType {
+		return urlsRequest{}, http.StatusUnsupportedMediaType, fmt.Errorf(
+			`unsupported %q header: expected %q: got %q`,
+			contentTypeHeader, contentTypeJSON, givenContentType)
+	}
+
+	if r.ContentLength == 0 {
+		return urlsRequest{}, http.StatusBadRequest, errors.New("bad request: empty request body")
+	}
+
+	var jsonReq urlsRequest
+	if err := json.NewDecoder(r.Body).Decode(&jsonReq); err != nil {
+		return urlsRequest{}, http.StatusBadRequest, jsonError(err)
+	}
+	return jsonReq, http.StatusOK, nil
+}
+
+// decodeForm reads the URLs from the repeated urls fields of a form-encoded body.
+func decodeForm(w http.ResponseWriter, r *http.Request) (urlsRequest, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseForm(); err != nil {
+		return urlsRequest{}, http.StatusBadRequest, fmt.Errorf("bad request: parse form: %s", err.Error())
+	}
+	return urlsRequest{URLs: r.PostForm[uploadField]}, http.StatusOK, nil
+}
+
+// decodeUpload reads the URLs from an uploaded file, which holds either
+// a JSON array of strings or one URL per line.
+func decodeUpload(w http.ResponseWriter, r *http.Request) (urlsRequest, int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		return urlsRequest{}, http.StatusBadRequest, fmt.Errorf("bad request: parse multipart form: %s", err.Error())
+	}
+	defer func() {
+		_ = r.MultipartForm.RemoveAll()
+	}()
+
+	file, _, err := r.FormFile(uploadField)
+	if err != nil {
+		return urlsRequest{}, http.StatusBadRequest, fmt.Errorf("bad request: read %q file: %s", uploadField, err.Error())
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return urlsRequest{}, http.StatusBadRequest, fmt.Errorf("bad request: read %q file: %s", uploadField, err.Error())
+	}
+	content = bytes.TrimSpace(content)
+
+	var req urlsRequest
+	if bytes.HasPrefix(content, []byte("[")) {
+		if err = json.Unmarshal(content, &req.URLs); err != nil {
+			return urlsRequest{}, http.StatusBadRequest, jsonError(err)
+		}
+		return req, http.StatusOK, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			req.URLs = append(req.URLs, line)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return urlsRequest{}, http.StatusBadRequest, fmt.Errorf("bad request: read %q file: %s", uploadField, err.Error())
+	}
+	return req, http.StatusOK, nil
+}
+
+// jsonError describes a JSON decoding error for the client.
+func jsonError(err error) error {
+	if ute, ok := err.(*json.UnmarshalTypeError); ok {
+		return fmt.Errorf("bad request: invalid type for %s: %v", ute.Value, ute.Type)
+	}
+	return fmt.Errorf("bad request: %s", err.Error())
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// retryAfterHeader tells throttled clients how long to back off.
+const retryAfterHeader = "Retry-After"
+
+// recordCrawlTime adds the duration of a finished crawl to the moving
+// average that throttled clients are told to wait for.
+func (a *app) recordCrawlTime(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&a.crawlTime)
+		avg := int64(d)
+		if old > 0 {
+			avg = old + (int64(d)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&a.crawlTime, old, avg) {
+			return
+		}
+	}
+}
+
+// quotaRetryAfter estimates when a busy API key gets a free crawl: the
+// average crawl duration so far or, before the first one is over, the
+// longest a crawl of MaxURLs URLs may take.
+func (a *app) quotaRetryAfter() time.Duration {
+	if avg := atomic.LoadInt64(&a.crawlTime); avg > 0 {
+		return time.Duration(avg)
+	}
+
+	maxURLs := a.maxURLs()
+	connections := int(a.config.Crawler.MaxConnections)
+	if connections == 0 || connections > maxURLs {
+		connections = maxURLs
+	}
+	rounds := (maxURLs + connections - 1) / connections
+	return time.Duration(rounds) * a.config.Crawler.RequestTimeout
+}
+
+// lameDuckRetryAfter tells clients of a draining instance to come back once
+// load balancers have taken it out of rotation, another instance answers then.
+func (a *app) lameDuckRetryAfter() time.Duration {
+	return a.config.GracefulDelay
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, at least one.
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := int64(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set(retryAfterHeader, strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+func TestRetryAfterOnThrottling(t *testing.T) {
+	upstream := newUpstream(t, `{"a":1}`)
+	urls := []string{upstream.URL}
+
+	t.Run("quota exceeded", func(t *testing.T) {
+		a, srv := newTestApp(t, Config{
+			DefaultKeyQuota: 1,
+			Crawler:         crawler.Config{RequestTimeout: 2 * time.Second},
+		})
+		release, ok := a.acquireQuota("")
+		if !ok {
+			t.Fatal("acquireQuota() = false, want the first crawl booked")
+		}
+		defer release()
+
+		resp, _ := postURLs(t, srv, urls, nil)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+		}
+		// Before any crawl is over, the longest a crawl may take.
+		if got := resp.Header.Get(retryAfterHeader); got != "2" {
+			t.Errorf("%s = %q, want %q", retryAfterHeader, got, "2")
+		}
+	})
+	t.Run("lame duck", func(t *testing.T) {
+		a, srv := newTestApp(t, Config{GracefulDelay: 5 * time.Second})
+		atomic.StoreInt32(&a.lameDuck, 1)
+
+		resp, _ := postURLs(t, srv, urls, nil)
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+		if got := resp.Header.Get(retryAfterHeader); got != "5" {
+			t.Errorf("%s = %q, want %q", retryAfterHeader, got, "5")
+		}
+	})
+}
+
+func TestQuotaRetryAfter(t *testing.T) {
+	a := &app{config: Config{MaxURLs: 10, Crawler: crawler.Config{MaxConnections: 4, RequestTimeout: time.Second}}}
+	if got, want := a.quotaRetryAfter(), 3*time.Second; got != want {
+		t.Errorf("before any crawl: quotaRetryAfter() = %s, want %s", got, want)
+	}
+
+	a.recordCrawlTime(800 * time.Millisecond)
+	if got, want := a.quotaRetryAfter(), 800*time.Millisecond; got != want {
+		t.Errorf("after a crawl: quotaRetryAfter() = %s, want %s", got, want)
+	}
+	a.recordCrawlTime(1600 * time.Millisecond)
+	if got, want := a.quotaRetryAfter(), 900*time.Millisecond; got != want {
+		t.Errorf("after two crawls: quotaRetryAfter() = %s, want %s", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	setRetryAfter(w, a.quotaRetryAfter())
+	if got := w.Header().Get(retryAfterHeader); got != "1" {
+		t.Errorf("%s = %q, want whole seconds rounded up: %q", retryAfterHeader, got, "1")
+	}
+}
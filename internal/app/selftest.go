@@ -0,0 +1,97 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// defaultSelfTestPath is where the self-test is served unless SelfTestPath is set.
+const defaultSelfTestPath = "/selftest"
+
+type (
+	selfTestResponse struct {
+		Status string          `json:"status"`
+		Checks []selfTestCheck `json:"checks"`
+	}
+	selfTestCheck struct {
+		URL        string `json:"url"`
+		Passed     bool   `json:"passed"`
+		StatusCode int    `json:"code,omitempty"`
+		Error      string `json:"error,omitempty"`
+		DurationMS int64  `json:"duration_ms"`
+	}
+)
+
+var errUnauthorized = errors.New("unauthorized: invalid or missing API key")
+
+// authorized reports whether the request passes the key in the X-Api-Key header.
+func authorized(r *http.Request, key string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(apiKeyHeader)), []byte(key)) == 1
+}
+
+// selfTestPath returns the path the self-test is served at.
+func (a *app) selfTestPath() string {
+	if a.config.SelfTestPath != "" {
+		return a.config.SelfTestPath
+	}
+	return defaultSelfTestPath
+}
+
+// selfTestHandler crawls every SelfTestURLs entry on its own through the same
+// crawler as regular requests, so DNS, TLS and proxy settings are all covered.
+// It answers with 200 if all of them passed, with 503 otherwise.
+func (a *app) selfTestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			invalidMethodErr := fmt.Errorf("method not allowed: expected %q: got %q", http.MethodGet, r.Method)
+			writeResponse(w, r, invalidMethodErr, http.StatusMethodNotAllowed)
+			log.Println("handler: selftest:", invalidMethodErr)
+			return
+		}
+
+		if !authorized(r, a.config.SelfTestKey) {
+			writeResponse(w, r, errUnauthorized, http.StatusUnauthorized)
+			log.Println("handler: selftest:", errUnauthorized)
+			return
+		}
+
+		response := selfTestResponse{Status: "pass", Checks: make([]selfTestCheck, len(a.config.SelfTestURLs))}
+
+		var wg sync.WaitGroup
+		for i, u := range a.config.SelfTestURLs {
+			wg.Add(1)
+			go func(check *selfTestCheck, u string) {
+				defer wg.Done()
+
+				start := a.clock.Now()
+				results, err := a.crawler.Crawl(r.Context(), []string{u})
+				check.DurationMS = a.clock.Now().Sub(start).Milliseconds()
+				cleanupResults(results)
+
+				check.URL = u
+				if len(results) > 0 {
+					check.StatusCode = results[0].StatusCode
+				}
+				if err != nil {
+					check.Error = err.Error()
+					return
+				}
+				check.Passed = true
+			}(&response.Checks[i], u)
+		}
+		wg.Wait()
+
+		code := http.StatusOK
+		for _, check := range response.Checks {
+			if !check.Passed {
+				response.Status, code = "fail", http.StatusServiceUnavailable
+				log.Printf("handler: selftest: %s: %s\n", check.URL, check.Error)
+			}
+		}
+		writeResponse(w, r, response, code)
+	})
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+// closeMargin is how much longer than the graceful shutdown the closer waits
+// for it, so that steps cut off by GracefulTimeout still get logged.
+const closeMargin = time.Second
+
+// shutdownStep is a named stage of the graceful shutdown.
+type shutdownStep struct {
+	name string
+	run  func() error
+}
+
+// shutdown releases resources in a fixed order, every step starts only when
+// the previous one is over: stop accepting new connections, drain HTTP and
+// then the monitoring server, and close idle crawler connections. served is
+// closed once srv.Serve returns. Steps keep running after a failure, the
+// first error is returned.
+func (a *app) shutdown(srv, monitoringSrv *http.Server, served <-chan struct{}) (err error) {
+	// Spilled bodies are removed only after in-flight responses are written.
+	defer a.removeSpillDir()
+
+	log.Printf("http: setting graceful timeout: %.2fs\n", a.config.GracefulTimeout.Seconds())
+	ctx, cancel := clock.WithTimeout(context.Background(), a.clock, a.config.GracefulTimeout)
+	defer cancel()
+
+	steps := []shutdownStep{
+		{name: "stop accepting", run: func() error {
+			log.Printf("http: awaiting traffic to stop: %.2fs\n", a.config.GracefulDelay.Seconds())
+			a.clock.Sleep(a.config.GracefulDelay)
+
+			log.Println("http: shutting down: disabling keep-alive")
+			srv.SetKeepAlivesEnabled(false)
+
+			// Connections accepted so far are left to the drain, srv.Shutdown
+			// finds no listener to close once Serve has returned.
+			if err := a.http.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			select {
+			case <-served:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}},
+		{name: "drain http", run: func() error {
+			return srv.Shutdown(ctx)
+		}},
+		{name: "drain monitoring", run: func() error {
+			if monitoringSrv == nil {
+				return nil
+			}
+			return monitoringSrv.Shutdown(ctx)
+		}},
+		{name: "close crawler", run: func() error {
+			return a.crawler.Close()
+		}},
+	}
+
+	for _, step := range steps {
+		if stepErr := step.run(); stepErr != nil {
+			log.Printf("http: shutting down: %s: %s\n", step.name, stepErr.Error())
+			if err == nil {
+				err = fmt.Errorf("http: shutting down: %s: %w", step.name, stepErr)
+			}
+			continue
+		}
+		log.Printf("http: shutting down: %s: done\n", step.name)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("http: gracefully stopped")
+	return nil
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+func TestShutdownUnderLoad(t *testing.T) {
+	const requests = 8
+
+	arrived, release := make(chan struct{}, requests), make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	instance, err := NewWithConfig(Config{
+		MaxConnections:  requests * 2,
+		GracefulTimeout: 5 * time.Second,
+		Crawler:         crawler.Config{LogLevel: crawler.LogLevelSilent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := instance.(*app)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(a.http.listener.Addr().(*net.TCPAddr).Port))
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- a.Run() }()
+
+	body := []byte(`{"urls":["` + upstream.URL + `"]}`)
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			resp, err := http.Post("http://"+addr+"/crawler", contentTypeJSON, bytes.NewReader(body))
+			if err != nil {
+				t.Errorf("in-flight request: %v", err)
+				codes <- 0
+				return
+			}
+			_, _ = ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		<-arrived
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		a.closer.Close()
+		close(shutdownDone)
+	}()
+
+	// New connections are refused while the accepted ones are still served.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		_ = conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("listener still accepts connections during shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown finished before in-flight requests")
+	default:
+	}
+
+	close(release)
+	for i := 0; i < requests; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("in-flight request: status = %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() didn't return after shutdown")
+	}
+}
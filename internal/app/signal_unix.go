@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import (
+	"os"
+	"syscall"
+)
+
+// lameDuckSignals switch the app to lame-duck mode.
+var lameDuckSignals = []os.Signal{syscall.SIGUSR1}
+
+// reloadSignals reload the host policy from HostPolicyFile.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package app
+
+import (
+	"os"
+)
+
+// lameDuckSignals switch the app to lame-duck mode, there is no suitable signal on Windows.
+var lameDuckSignals []os.Signal
+
+// reloadSignals reload the host policy from HostPolicyFile, there is no suitable signal on Windows.
+var reloadSignals []os.Signal
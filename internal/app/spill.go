@@ -0,0 +1,206 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/alexeykhan/multiplexer/pkg/crawler"
+)
+
+// writeSpilledResponse streams the results whose bodies were spilled to disk,
+// so that only one body at a time passes through memory. Spilled files are
+// removed once the response is written, even if writing fails halfway.
+func (a *app) writeSpilledResponse(w http.ResponseWriter, r *http.Request, results []crawler.Result, encoding string, byURL bool) {
+	defer cleanupResults(results)
+
+	// HTTP/1.0 clients can't receive a chunked response: assemble it on disk
+	// first to send it with a known Content-Length.
+	if !r.ProtoAtLeast(1, 1) {
+		a.writeBufferedSpilledResponse(w, r, results, encoding, byURL)
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+
+	bw := bufio.NewWriter(w)
+	if err := encodeSpilledResults(bw, results, encoding, byURL); err != nil {
+		log.Println("response: write spilled results:", err)
+		return
+	}
+	if err := bw.Flush(); err != nil {
+		log.Println("response: write data to buffer:", err)
+	}
+}
+
+// cleanupResults removes spilled bodies of the results.
+func cleanupResults(results []crawler.Result) {
+	for _, res := range results {
+		if err := res.Cleanup(); err != nil {
+			log.Println("response: remove spilled body:", err)
+		}
+	}
+}
+
+// writeBufferedSpilledResponse encodes the results to a temp file and sends it
+// as a regular response with the Content-Length header set.
+func (a *app) writeBufferedSpilledResponse(w http.ResponseWriter, r *http.Request, results []crawler.Result, encoding string, byURL bool) {
+	f, err := ioutil.TempFile(a.spillDir, "response-*.json")
+	if err != nil {
+		log.Println("response: create temp file:", err)
+		writeResponse(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Println("response: close temp file:", err)
+		}
+		if err := os.Remove(f.Name()); err != nil {
+			log.Println("response: remove temp file:", err)
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if err = encodeSpilledResults(bw, results, encoding, byURL); err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		log.Println("response: write spilled results:", err)
+		writeResponse(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Println("response: rewind temp file:", err)
+		writeResponse(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentTypeHeader, contentTypeJSON)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err = io.Copy(w, f); err != nil {
+		log.Println("response: write data to buffer:", err)
+	}
+}
+
+// encodeSpilledResults writes the results in the same format as writeResponse
+// does, either as an array or as an object keyed by URL.
+func encodeSpilledResults(w *bufio.Writer, results []crawler.Result, encoding string, byURL bool) error {
+	head, tail := `{"results":[`, `]}`
+	if byURL {
+		head, tail = `{"results":{`, `}}`
+		results = lastPerURL(results)
+	}
+
+	if _, err := w.WriteString(head); err != nil {
+		return err
+	}
+	for i, res := range results {
+		if i > 0 {
+			if err := w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		if err := encodeSpilledResult(w, res, encoding, byURL); err != nil {
+			return err
+		}
+	}
+	_, err := w.WriteString(tail)
+	return err
+}
+
+// encodeSpilledResult writes a single result in the urlsResult format, or as
+// a member of the object keyed by URL.
+func encodeSpilledResult(w io.Writer, res crawler.Result, encoding string, byURL bool) error {
+	sourceURL, err := json.Marshal(res.SourceURL)
+	if err != nil {
+		return fmt.Errorf("marshal url: %w", err)
+	}
+
+	if byURL {
+		if _, err = fmt.Fprintf(w, `%s:`, sourceURL); err != nil {
+			return err
+		}
+		return encodeSpilledResponse(w, res, encoding)
+	}
+
+	if _, err = fmt.Fprintf(w, `{"url":%s,"response":`, sourceURL); err != nil {
+		return err
+	}
+	if err = encodeSpilledResponse(w, res, encoding); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `}`)
+	return err
+}
+
+// encodeSpilledResponse writes the response of a result, copying the body
+// straight from its file.
+func encodeSpilledResponse(w io.Writer, res crawler.Result, encoding string) error {
+	if _, err := fmt.Fprintf(w, `{"code":%d,"body":`, res.StatusCode); err != nil {
+		return err
+	}
+
+	encoding, err := encodeSpilledBody(w, res, encoding)
+	if err != nil {
+		return err
+	}
+	if encoding != "" {
+		if _, err = fmt.Fprintf(w, `,"encoding":%q`, encoding); err != nil {
+			return err
+		}
+	}
+	if res.Truncated {
+		if _, err = io.WriteString(w, `,"truncated":true`); err != nil {
+			return err
+		}
+	}
+	if res.Err() != nil {
+		message, err := json.Marshal(res.Err().Error())
+		if err != nil {
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		if _, err = fmt.Fprintf(w, `,"error":%s`, message); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, `}`)
+	return err
+}
+
+// encodeSpilledBody copies the body of a result and returns the encoding it
+// was written in. Bodies that the crawler didn't store are sent as null.
+func encodeSpilledBody(w io.Writer, res crawler.Result, encoding string) (string, error) {
+	if res.BodyPath == "" && res.ResponseBody == nil {
+		_, err := io.WriteString(w, `null`)
+		return "", err
+	}
+
+	body, err := res.Body()
+	if err != nil {
+		return "", fmt.Errorf("open body: %w", err)
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			log.Println("response: close spilled body:", err)
+		}
+	}()
+
+	if encoding, err = encodeResultBody(w, res, body, encoding); err != nil {
+		return "", fmt.Errorf("copy body: %w", err)
+	}
+	return encoding, nil
+}
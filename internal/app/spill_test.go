@@ -0,0 +1,59 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSpilledResponseToHTTP10Client(t *testing.T) {
+	// Large enough not to fit in the buffer of the response writer, which
+	// would let net/http set the Content-Length on its own.
+	upstream := newUpstream(t, `{"a":"`+strings.Repeat("x", 64<<10)+`"}`)
+	_, srv := newTestApp(t, Config{SpillResults: true})
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	body := `{"urls":["` + upstream.URL + `"]}`
+	_, err = fmt.Fprintf(conn, "POST /crawler HTTP/1.0\r\nHost: test\r\nContent-Type: application/json\r\nConnection: close\r\nContent-Length: %d\r\n\r\n%s",
+		len(body), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(resp.TransferEncoding) > 0 {
+		t.Errorf("Transfer-Encoding = %q, want none for HTTP/1.0", resp.TransferEncoding)
+	}
+	if resp.ContentLength <= 0 {
+		t.Errorf("Content-Length = %d, want it set", resp.ContentLength)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	var decoded struct {
+		Results []urlsResult `json:"results"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	if len(decoded.Results) != 1 || !strings.Contains(string(decoded.Results[0].Response.ResponseBody), `"a":"xxx`) {
+		t.Errorf("results = %s, want the upstream body", data)
+	}
+}
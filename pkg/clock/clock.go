@@ -0,0 +1,111 @@
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock tells the time and schedules events, so that code depending
+	// on timeouts and delays can be driven by a fake clock.
+	Clock interface {
+		Now() time.Time
+		After(d time.Duration) <-chan time.Time
+		NewTimer(d time.Duration) Timer
+		Sleep(d time.Duration)
+	}
+	// Timer is a single event, see time.Timer.
+	Timer interface {
+		C() <-chan time.Time
+		Stop() bool
+		Reset(d time.Duration) bool
+	}
+	realClock struct{}
+	realTimer struct {
+		*time.Timer
+	}
+	// timeoutContext is done once its timer fires, the parent is done or
+	// it is canceled, whatever happens first.
+	timeoutContext struct {
+		context.Context // Parent context, provides deadline and values.
+		sync.Mutex
+		once sync.Once
+		done chan struct{}
+		err  error
+	}
+)
+
+// Interface compliance check.
+var (
+	_ Clock = realClock{}
+	_ Timer = realTimer{}
+)
+
+// New returns the system clock.
+func New() Clock {
+	return realClock{}
+}
+
+// OrNew returns c, or the system clock if c is nil.
+func OrNew(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+	return c
+}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// WithTimeout works like context.WithTimeout, but measures the timeout with c.
+func WithTimeout(parent context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := c.(realClock); ok {
+		return context.WithTimeout(parent, d)
+	}
+
+	tc := &timeoutContext{Context: parent, done: make(chan struct{})}
+	timer := c.NewTimer(d)
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-parent.Done():
+			tc.finish(parent.Err())
+		case <-timer.C():
+			tc.finish(context.DeadlineExceeded)
+		case <-tc.done:
+		}
+	}()
+	return tc, func() { tc.finish(context.Canceled) }
+}
+
+// finish closes the context with err, unless it is closed already.
+func (tc *timeoutContext) finish(err error) {
+	tc.once.Do(func() {
+		tc.Lock()
+		tc.err = err
+		tc.Unlock()
+		close(tc.done)
+	})
+}
+
+// Done returns a channel that is closed on timeout or cancellation.
+func (tc *timeoutContext) Done() <-chan struct{} {
+	return tc.done
+}
+
+// Err returns context.DeadlineExceeded if the context is done on timeout.
+func (tc *timeoutContext) Err() error {
+	tc.Lock()
+	defer tc.Unlock()
+
+	return tc.err
+}
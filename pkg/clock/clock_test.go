@@ -0,0 +1,63 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithTimeoutFake(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	ctx, cancel := WithTimeout(context.Background(), fake, time.Second)
+	defer cancel()
+
+	fake.Advance(time.Second - time.Nanosecond)
+	select {
+	case <-ctx.Done():
+		t.Fatal("context done before the timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fake.Advance(time.Nanosecond)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not done after the timeout")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Errorf("Err() = %v, want %v", ctx.Err(), context.DeadlineExceeded)
+	}
+	if fake.Timers() != 0 {
+		t.Errorf("Timers() = %d after the timeout, want 0", fake.Timers())
+	}
+}
+
+func TestWithTimeoutFakeCanceled(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel := WithTimeout(parent, fake, time.Second)
+	defer cancel()
+
+	cancelParent()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not done after the parent")
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("Err() = %v, want %v", ctx.Err(), context.Canceled)
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), fake, time.Second)
+	cancel()
+	<-ctx.Done()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("canceled: Err() = %v, want %v", ctx.Err(), context.Canceled)
+	}
+	// The timer goroutine stops it once the context is done.
+	for deadline := time.Now().Add(5 * time.Second); fake.Timers() > 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timer still active after the cancel")
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Fake is a Clock that stands still until Advance is called.
+	Fake struct {
+		sync.Mutex
+		now    time.Time
+		timers map[*fakeTimer]struct{}
+	}
+	fakeTimer struct {
+		clock    *Fake
+		c        chan time.Time
+		deadline time.Time
+	}
+)
+
+// Interface compliance check.
+var (
+	_ Clock = (*Fake)(nil)
+	_ Timer = (*fakeTimer)(nil)
+)
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, timers: make(map[*fakeTimer]struct{})}
+}
+
+// Now returns the current fake time.
+func (f *Fake) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.now
+}
+
+// After waits for the fake time to pass and then sends it on the channel.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires once the fake time passes d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// Sleep blocks until the fake time passes d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the fake time forward and fires the timers that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.now = f.now.Add(d)
+	for t := range f.timers {
+		if !t.deadline.After(f.now) {
+			delete(f.timers, t)
+			select {
+			case t.c <- f.now:
+			default:
+			}
+		}
+	}
+}
+
+// Timers returns the number of timers waiting to fire, including the ones
+// of blocked Sleep and After calls. Tests use it to find out when the code
+// under test is waiting for the time to pass.
+func (f *Fake) Timers() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return len(f.timers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+// Stop prevents the timer from firing.
+func (t *fakeTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+// Reset changes the timer to fire once the fake time passes d.
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	_, active := t.clock.timers[t]
+	t.deadline = t.clock.now.Add(d)
+	if d <= 0 {
+		delete(t.clock.timers, t)
+		select {
+		case t.c <- t.clock.now:
+		default:
+		}
+		return active
+	}
+	t.clock.timers[t] = struct{}{}
+	return active
+}
@@ -0,0 +1,102 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeAdvance(t *testing.T) {
+	start := time.Unix(0, 0)
+	fake := NewFake(start)
+	fake.Advance(time.Minute)
+	if got := fake.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Now() = %s, want %s", got, start.Add(time.Minute))
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	timer := fake.NewTimer(time.Second)
+	if got := fake.Timers(); got != 1 {
+		t.Fatalf("Timers() = %d, want 1", got)
+	}
+
+	fake.Advance(time.Second - time.Nanosecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+
+	fake.Advance(time.Nanosecond)
+	select {
+	case now := <-timer.C():
+		if !now.Equal(fake.Now()) {
+			t.Errorf("timer sent %s, want %s", now, fake.Now())
+		}
+	default:
+		t.Fatal("timer didn't fire at its deadline")
+	}
+	if fake.Timers() != 0 {
+		t.Errorf("Timers() = %d after the timer fired, want 0", fake.Timers())
+	}
+	if timer.Stop() {
+		t.Error("Stop() = true on a fired timer, want false")
+	}
+}
+
+func TestFakeTimerStopAndReset(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	timer := fake.NewTimer(time.Second)
+	if !timer.Stop() {
+		t.Error("Stop() = false on an active timer, want true")
+	}
+	fake.Advance(time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("Reset() = true on a stopped timer, want false")
+	}
+	fake.Advance(time.Second)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("reset timer didn't fire")
+	}
+
+	// A timer reset to zero fires right away.
+	timer.Reset(0)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("timer reset to zero didn't fire")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	fake := NewFake(time.Unix(0, 0))
+	woke := make(chan struct{})
+	go func() {
+		fake.Sleep(time.Second)
+		close(woke)
+	}()
+	for fake.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-woke:
+		t.Fatal("Sleep() returned before the fake time passed")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case <-woke:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep() didn't return after the fake time passed")
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
 )
 
 type (
@@ -13,11 +16,21 @@ type (
 		Wait()
 		Close()
 	}
+	Config struct {
+		Signals []os.Signal // Close is called on any of them.
+
+		// Timeout bounds Close: functions still running after it are
+		// logged and left behind, zero waits for all of them.
+		Timeout time.Duration
+		Clock   clock.Clock // Measures Timeout, the system clock if nil.
+	}
 	closer struct {
 		sync.Mutex
-		once  sync.Once
-		done  chan struct{}
-		funcs []func() error
+		config Config
+		clock  clock.Clock
+		once   sync.Once
+		done   chan struct{}
+		funcs  []func() error
 	}
 )
 
@@ -27,11 +40,16 @@ var _ Closer = (*closer)(nil)
 // NewCloser returns new Closer. If any os.Signal is specified, Closer will
 // call Close when it receives one of the signals from the OS.
 func New(sig ...os.Signal) Closer {
-	c := &closer{done: make(chan struct{})}
-	if len(sig) > 0 {
+	return NewWithConfig(Config{Signals: sig})
+}
+
+// NewWithConfig returns new Closer with custom settings.
+func NewWithConfig(cfg Config) Closer {
+	c := &closer{config: cfg, clock: clock.OrNew(cfg.Clock), done: make(chan struct{})}
+	if len(cfg.Signals) > 0 {
 		go func() {
 			ch := make(chan os.Signal, 1)
-			signal.Notify(ch, sig...)
+			signal.Notify(ch, cfg.Signals...)
 			stop := <-ch
 			signal.Stop(ch)
 			log.Printf("OS signal received: %s\n", stop.String())
@@ -70,9 +88,21 @@ func (c *closer) Close() {
 			}(f)
 		}
 
+		var timeout <-chan time.Time
+		if c.config.Timeout > 0 {
+			timer := c.clock.NewTimer(c.config.Timeout)
+			defer timer.Stop()
+			timeout = timer.C()
+		}
 		for i := 0; i < cap(errs); i++ {
-			if err := <-errs; err != nil {
-				log.Printf("closer: %s", err.Error())
+			select {
+			case err := <-errs:
+				if err != nil {
+					log.Printf("closer: %s", err.Error())
+				}
+			case <-timeout:
+				log.Printf("closer: timeout of %s: %d functions still running\n", c.config.Timeout, cap(errs)-i)
+				return
 			}
 		}
 	})
@@ -0,0 +1,60 @@
+package closer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+func TestCloseRunsAllFunctions(t *testing.T) {
+	c := New()
+	ran := make(chan struct{}, 2)
+	c.Add(func() error {
+		ran <- struct{}{}
+		return nil
+	}, func() error {
+		ran <- struct{}{}
+		return errors.New("logged and ignored")
+	})
+
+	c.Close()
+	c.Wait()
+	if len(ran) != 2 {
+		t.Errorf("ran %d functions, want 2", len(ran))
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	c := NewWithConfig(Config{Timeout: time.Second, Clock: fake})
+	stuck := make(chan struct{})
+	defer close(stuck)
+	c.Add(func() error {
+		<-stuck
+		return nil
+	})
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	for fake.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close() returned before the timeout")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() didn't return after the timeout")
+	}
+	c.Wait()
+}
@@ -0,0 +1,196 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+// batch is a running batch of requests.
+type batch struct {
+	results <-chan Result // Closed once all of the results are sent.
+	ctx     context.Context
+	budget  context.Context // Runs out with BatchTimeout.
+	stop    []context.CancelFunc
+}
+
+// cancel stops the workers of the batch, it has to be called once it is done.
+func (b *batch) cancel() {
+	for _, stop := range b.stop {
+		stop()
+	}
+}
+
+// budgetExceeded reports whether BatchTimeout stopped the batch, as opposed
+// to the caller's context or cancel.
+func (b *batch) budgetExceeded() bool {
+	return b.budget.Err() != nil && b.ctx.Err() == nil
+}
+
+// startBatch validates the requests and starts crawling them.
+func (cr *crawler) startBatch(ctx context.Context, requests []Request) (*batch, error) {
+	select {
+	case <-ctx.Done():
+		cr.infoln("crawler: exit on context done:", ctx.Err())
+		return nil, ctx.Err()
+	default:
+	}
+
+	cr.debugf("crawler: received %d tasks: validating URL format\n", len(requests))
+
+	// The whole batch is checked against the same policy, redirects too.
+	policy := cr.hostPolicy()
+	ctx = withHostPolicy(ctx, policy)
+	if cr.config.EnableCookieJar {
+		ctx = withCookieJar(ctx)
+	}
+
+	var hosts map[string]string
+	if cr.config.BlockPrivateNetworks {
+		hosts = make(map[string]string)
+	}
+
+	tasks := make([]task, 0, len(requests))
+	for i, checkURL := range requests {
+		uri, err := cr.validateURL(checkURL.URL, policy)
+		if err != nil {
+			cr.errorln("crawler:", err)
+			return nil, err
+		}
+		method := checkURL.Method
+		if method == "" {
+			method = cr.config.Method
+		}
+		if method == "" {
+			method = http.MethodGet
+		}
+		if !validMethod(method) {
+			cr.errorf("crawler: invalid method: %s: %s\n", method, checkURL.URL)
+			return nil, fmt.Errorf("invalid method: %q: %q", method, checkURL.URL)
+		}
+		tasks = append(tasks, task{
+			index:    i,
+			url:      checkURL.URL,
+			method:   method,
+			body:     checkURL.Body,
+			header:   checkURL.Header,
+			host:     strings.ToLower(uri.Host),
+			priority: checkURL.Priority,
+		})
+		if hosts != nil {
+			if _, ok := hosts[uri.Hostname()]; !ok {
+				hosts[uri.Hostname()] = checkURL.URL
+			}
+		}
+	}
+
+	if len(hosts) > 0 {
+		if err := cr.checkPrivateNetworks(ctx, hosts); err != nil {
+			cr.errorln("crawler:", err)
+			return nil, err
+		}
+	}
+
+	var duplicates map[int][]int
+	if cr.config.Deduplicate {
+		tasks, duplicates = deduplicate(tasks)
+	}
+
+	if cr.config.FairQueuing {
+		interleaveHosts(tasks)
+	}
+
+	var pending *queue
+	if perHost := cr.maxPerHost(); perHost > 0 {
+		pending = newHostLimitedQueue(tasks, perHost)
+	} else {
+		pending = newQueue(tasks)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	b := &batch{ctx: ctx, budget: ctx, stop: []context.CancelFunc{cancel}}
+
+	// Workers get the batch budget, ctx alone tells it from the caller's deadline.
+	if cr.config.BatchTimeout > 0 {
+		var cancelBudget context.CancelFunc
+		b.budget, cancelBudget = clock.WithTimeout(ctx, cr.clock, cr.config.BatchTimeout)
+		b.stop = append(b.stop, cancelBudget)
+	}
+
+	// Given condition: limit the number of outgoing requests.
+	numWorkers := int(cr.config.MaxConnections)
+	if numWorkers == 0 || numWorkers > len(tasks) {
+		numWorkers = len(tasks)
+	}
+
+	crawled := make(chan Result)
+	wg := &sync.WaitGroup{}
+	wg.Add(numWorkers)
+
+	cr.debugf("crawler: starting %d workers\n", numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go cr.worker(b.budget, wg, pending, crawled)
+	}
+
+	go func() {
+		wg.Wait()
+		close(crawled)
+		cr.debugln("crawler: results channel closed")
+	}()
+
+	// Keep expensive transforms from holding up the outgoing requests.
+	var results <-chan Result = crawled
+	if cr.config.Transform != nil && cr.config.TransformWorkers > 0 {
+		results = cr.transformStage(crawled)
+	}
+	if len(duplicates) > 0 {
+		results = fanOutStage(results, duplicates)
+	}
+	b.results = results
+	return b, nil
+}
+
+// ValidateURLs checks the URLs by the same rules as a batch does before any
+// request is sent and returns an *InvalidURLError for each of those that fail,
+// nil if all of them pass. Hosts aren't resolved, so BlockPrivateNetworks
+// only refuses addresses given as they are.
+func (cr *crawler) ValidateURLs(urls []string) []error {
+	policy := cr.hostPolicy()
+
+	var errs []error
+	for i, u := range urls {
+		uri, err := cr.validateURL(u, policy)
+		if err == nil && cr.config.BlockPrivateNetworks {
+			if ip := net.ParseIP(uri.Hostname()); ip != nil && privateIP(ip) {
+				err = fmt.Errorf("%w: %q", ErrPrivateNetwork, u)
+			}
+		}
+		if err != nil {
+			errs = append(errs, &InvalidURLError{Index: i, URL: u, Err: err})
+		}
+	}
+	return errs
+}
+
+// validateURL parses the URL of a request and checks that it may be crawled.
+func (cr *crawler) validateURL(u string, policy HostPolicy) (*url.URL, error) {
+	// Check general cases for invalid URLs.
+	// Unfortunately, cases like "http://invalidurl" successfully pass this check.
+	uri, err := url.ParseRequestURI(u)
+	if err != nil || uri.Host == "" || uri.Scheme == "" {
+		return nil, fmt.Errorf("invalid url: %q", u)
+	}
+	if !cr.allowsScheme(uri.Scheme) {
+		return nil, fmt.Errorf("unsupported url scheme: %q: %q", uri.Scheme, u)
+	}
+	if !policy.allows(uri.Host) {
+		return nil, fmt.Errorf("%w: %q", ErrHostNotAllowed, u)
+	}
+	return uri, nil
+}
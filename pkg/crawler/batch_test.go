@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchTimeoutKeepsCompletedResults(t *testing.T) {
+	fast := newJSONServer(t, `{"fast":true}`)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	const budget = 200 * time.Millisecond
+	for _, continueOnError := range []bool{false, true} {
+		cr := newTestCrawler(t, Config{BatchTimeout: budget, ContinueOnError: continueOnError})
+		urls := []string{fast.URL + "/1", slow.URL, fast.URL + "/2", slow.URL}
+
+		start := time.Now()
+		results, err := cr.Crawl(context.Background(), urls)
+		if elapsed := time.Since(start); elapsed > budget+time.Second {
+			t.Errorf("continue %t: Crawl() took %s, want about %s", continueOnError, elapsed, budget)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("continue %t: error = %v, want %v", continueOnError, err, context.DeadlineExceeded)
+		}
+
+		var done []string
+		for _, res := range results {
+			if res.Err() == nil {
+				done = append(done, res.SourceURL)
+			}
+		}
+		if len(done) != 2 || done[0] != urls[0] || done[1] != urls[2] {
+			t.Errorf("continue %t: completed = %q, want the fast URLs in order", continueOnError, done)
+		}
+		if wantResults := map[bool]int{false: 2, true: 4}[continueOnError]; len(results) != wantResults {
+			t.Errorf("continue %t: got %d results, want %d", continueOnError, len(results), wantResults)
+		}
+	}
+}
+
+func TestUnlimitedConnectionsCrawlWholeBatchAtOnce(t *testing.T) {
+	const urls = 50
+
+	// Every request waits for all of them, so the batch only completes
+	// if none of them waits for a free connection.
+	var arrived int32
+	all := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&arrived, 1) == urls {
+			close(all)
+		}
+		select {
+		case <-all:
+			_, _ = w.Write([]byte(`{}`))
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	batch := make([]string, urls)
+	for i := range batch {
+		batch[i] = srv.URL + "/" + strconv.Itoa(i)
+	}
+	cr := newTestCrawler(t, Config{MaxConnections: 0})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := cr.Crawl(ctx, batch)
+	if err != nil {
+		t.Fatalf("Crawl() error = %v after %d of %d requests arrived", err, atomic.LoadInt32(&arrived), urls)
+	}
+	if len(results) != urls {
+		t.Errorf("Crawl() returned %d results, want %d", len(results), urls)
+	}
+}
@@ -0,0 +1,151 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxDrainBytes is the most of an unread body discarded to reuse the
+// connection, dropping the connection is cheaper than reading more.
+const maxDrainBytes = 64 << 10
+
+var (
+	// ErrBodyTooLarge fails responses with a body larger than MaxBodySize.
+	ErrBodyTooLarge = errors.New("response body exceeds MaxBodySize")
+
+	// ErrTruncatedBody fails responses whose body ended before the
+	// Content-Length they declared.
+	ErrTruncatedBody = errors.New("truncated response body")
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// readBody reads the body to the end or until ctx is done, whatever happens
+// first. net/http aborts reads on its own once the request context is done,
+// closing the body covers transports that don't. With decode, gzip and
+// deflate bodies the transport left compressed, which it does when the
+// request set its own Accept-Encoding, are decompressed. Bodies of more
+// than limit bytes, decompressed ones included, fail with ErrBodyTooLarge
+// as soon as the limit is crossed; zero means no limit. It also returns the
+// number of bytes read off the wire, which bodies shorter than a declared
+// Content-Length fail with ErrTruncatedBody against.
+func readBody(ctx context.Context, resp *http.Response, limit int64, decode bool) ([]byte, int64, error) {
+	var encoding string
+	if decode && !resp.Uncompressed {
+		encoding = strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	}
+
+	// The length of a compressed body says nothing about the decompressed one.
+	if limit > 0 && !compressed(encoding) && resp.ContentLength > limit {
+		return nil, 0, fmt.Errorf("%w of %d bytes: content length is %d", ErrBodyTooLarge, limit, resp.ContentLength)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	wire := &countingReader{r: resp.Body}
+	body, err := decompress(wire, encoding)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, wire.n, ctx.Err()
+		}
+		return nil, wire.n, err
+	}
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil && ctx.Err() != nil {
+		return nil, wire.n, ctx.Err()
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, wire.n, fmt.Errorf("%w of %d bytes", ErrBodyTooLarge, limit)
+	}
+	// net/http reports most of the short bodies as unexpected EOF, others
+	// end as if they were complete. Decompressors may stop short of trailing
+	// bytes, and responses to HEAD declare the length of a body they don't have.
+	if (err == nil || errors.Is(err, io.ErrUnexpectedEOF)) && !compressed(encoding) && resp.Request.Method != http.MethodHead &&
+		resp.ContentLength >= 0 && wire.n < resp.ContentLength {
+		err = fmt.Errorf("%w: read %d of %d bytes", ErrTruncatedBody, wire.n, resp.ContentLength)
+	}
+	return data, wire.n, err
+}
+
+// drainAndClose discards what is left of the body, so that the connection
+// goes back to the pool, and closes it. Bodies of requests whose ctx is done
+// aren't drained: the transport drops their connections anyway.
+func drainAndClose(ctx context.Context, body io.ReadCloser) error {
+	if ctx.Err() == nil {
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(body, maxDrainBytes))
+	}
+	return body.Close()
+}
+
+// compressed reports whether decompress decodes bodies of the encoding.
+func compressed(encoding string) bool {
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+		return true
+	default:
+		return false
+	}
+}
+
+// decompress returns a reader of the decoded body, the body itself if
+// the encoding isn't compressed. Deflate bodies are expected in the zlib
+// format, as the spec has it, but raw deflate streams that some servers
+// send are read too.
+func decompress(body io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("decompress gzip body: %w", err)
+		}
+		return gz, nil
+	case "deflate":
+		buffered := bufio.NewReader(body)
+		header, err := buffered.Peek(2)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("decompress deflate body: %w", err)
+		}
+		if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			zr, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, fmt.Errorf("decompress deflate body: %w", err)
+			}
+			return zr, nil
+		}
+		return flate.NewReader(buffered), nil
+	default:
+		return body, nil
+	}
+}
@@ -0,0 +1,227 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingBody is a response body whose reads block until it is closed,
+// like one of a transport that ignores the request context.
+type blockingBody struct {
+	closed chan struct{}
+}
+
+func (b *blockingBody) Read([]byte) (int, error) {
+	<-b.closed
+	return 0, errors.New("read on closed body")
+}
+
+func (b *blockingBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+// newSlowBodyServer returns a server that sends the start of a body and then
+// stalls until the client goes away. connState, if not nil, is the server's
+// ConnState hook.
+func newSlowBodyServer(t *testing.T, connState func(net.Conn, http.ConnState)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[1,2,`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	srv.Config.ConnState = connState
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReadBodyCanceledMidway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	resp := &http.Response{
+		Body:          &blockingBody{closed: make(chan struct{})},
+		ContentLength: -1,
+		Request:       &http.Request{Method: http.MethodGet},
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, _, err := readBody(ctx, resp, 0, true)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("readBody() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("readBody() took %s after the cancel", elapsed)
+	}
+}
+
+func TestCrawlCanceledDuringSlowBody(t *testing.T) {
+	srv := newSlowBodyServer(t, nil)
+	cr := newTestCrawler(t, Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := cr.Crawl(ctx, []string{srv.URL})
+	if err == nil {
+		t.Fatal("Crawl() error = nil, want the context's")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Crawl() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Crawl() took %s, want it to return once ctx is done", elapsed)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	const limit = 16
+	small, large := `{"a":1}`, `{"a":"`+strings.Repeat("x", 2*limit)+`"}`
+
+	chunked := func(body string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Flushing early leaves the body without a Content-Length.
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte(body))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	tests := []struct {
+		name    string
+		srv     *httptest.Server
+		wantErr bool
+	}{
+		{name: "content length", srv: newJSONServer(t, large), wantErr: true},
+		{name: "chunked", srv: chunked(large), wantErr: true},
+		{name: "gzip", srv: newGzipServer(t, large), wantErr: true},
+		{name: "within limit", srv: chunked(small)},
+		{name: "gzip within limit", srv: newGzipServer(t, small)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCrawler(t, Config{MaxBodySize: limit})
+			results, err := cr.Crawl(context.Background(), []string{tt.srv.URL})
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Crawl() error = %v", err)
+				}
+				if got := string(results[0].ResponseBody); got != small {
+					t.Errorf("ResponseBody = %q, want %q", got, small)
+				}
+				return
+			}
+			if !errors.Is(err, ErrBodyTooLarge) {
+				t.Fatalf("Crawl() error = %v, want %v", err, ErrBodyTooLarge)
+			}
+			if !strings.Contains(err.Error(), tt.srv.URL) {
+				t.Errorf("Crawl() error = %q, want it to name %s", err, tt.srv.URL)
+			}
+		})
+	}
+}
+
+// countingBody is an endless response body that counts the bytes read.
+type countingBody struct {
+	n      int64
+	closed bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	b.n += int64(len(p))
+	return len(p), nil
+}
+
+func (b *countingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestDrainAndClose(t *testing.T) {
+	body := &countingBody{}
+	if err := drainAndClose(context.Background(), body); err != nil {
+		t.Fatalf("drainAndClose() error = %v", err)
+	}
+	if body.n != maxDrainBytes || !body.closed {
+		t.Errorf("drainAndClose() read %d bytes, closed %t, want %d bytes and closed", body.n, body.closed, maxDrainBytes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body = &countingBody{}
+	if err := drainAndClose(ctx, body); err != nil {
+		t.Fatalf("canceled: drainAndClose() error = %v", err)
+	}
+	if body.n != 0 || !body.closed {
+		t.Errorf("canceled: drainAndClose() read %d bytes, closed %t, want none and closed", body.n, body.closed)
+	}
+}
+
+func TestCrawlCanceledDuringSlowBodyClosesConnection(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	srv := newSlowBodyServer(t, func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	})
+	cr := newTestCrawler(t, Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := cr.Crawl(ctx, []string{srv.URL}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Crawl() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The slow body holds the server's handler for 10 seconds unless the
+	// client drops the connection instead of keeping it for reuse.
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("connection still open after the crawl was canceled")
+	}
+}
+
+func TestBytesRead(t *testing.T) {
+	const body = `{"a":[1,2,3]}`
+	tests := []struct {
+		name    string
+		srv     *httptest.Server
+		wantErr error
+	}{
+		{name: "complete", srv: newJSONServer(t, body)},
+		{name: "truncated", srv: newTruncatedServer(t, body, len(body)+10), wantErr: ErrTruncatedBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newTestCrawler(t, Config{ContinueOnError: true})
+			results, _ := cr.Crawl(context.Background(), []string{tt.srv.URL})
+			if len(results) != 1 {
+				t.Fatalf("Crawl() returned %d results, want 1", len(results))
+			}
+			res := results[0]
+			if tt.wantErr == nil && res.Err() != nil {
+				t.Errorf("Err() = %v", res.Err())
+			}
+			if tt.wantErr != nil && !errors.Is(res.Err(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", res.Err(), tt.wantErr)
+			}
+			if res.BytesRead != int64(len(body)) {
+				t.Errorf("BytesRead = %d, want the %d bytes sent", res.BytesRead, len(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the number of responses cached unless CacheSize is set.
+const defaultCacheSize = 1000
+
+type (
+	// responseCache keeps successful results of GET requests for a while,
+	// dropping the least recently used ones once it is full.
+	responseCache struct {
+		sync.Mutex
+		ttl     time.Duration
+		size    int
+		entries map[string]*list.Element
+		lru     *list.List // Of *cacheEntry, the most recently used first.
+	}
+	cacheEntry struct {
+		key     string
+		res     Result
+		expires time.Time
+	}
+)
+
+// newResponseCache returns the cache for the config, nil if it is disabled.
+func newResponseCache(cfg Config) *responseCache {
+	if cfg.CacheTTL <= 0 {
+		return nil
+	}
+	size := cfg.CacheSize
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &responseCache{
+		ttl:     cfg.CacheTTL,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// PurgeCache drops all of the cached responses.
+func (cr *crawler) PurgeCache() {
+	if cr.cache == nil {
+		return
+	}
+
+	cr.cache.Lock()
+	defer cr.cache.Unlock()
+
+	cr.cache.entries = make(map[string]*list.Element)
+	cr.cache.lru.Init()
+}
+
+// cacheKey returns the key the result of the task is cached by, empty if
+// it isn't cached at all.
+func (c *responseCache) cacheKey(t task) string {
+	if c == nil || t.method != http.MethodGet {
+		return ""
+	}
+	return t.dedupKey()
+}
+
+// get returns the unexpired result cached by the key.
+func (c *responseCache) get(key string, now time.Time) (Result, bool) {
+	if key == "" {
+		return Result{}, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return Result{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return Result{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.res, true
+}
+
+// put caches the result by the key, unless it failed, has no body for being
+// spilled to disk or not modified, or the response asked not to be stored.
+func (c *responseCache) put(key string, res Result, now time.Time) {
+	if key == "" || res.err != nil || res.BodyPath != "" || res.noStore || res.NotModified {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	entry := &cacheEntry{key: key, res: res, expires: now.Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// noStore reports whether the response asks not to be stored.
+func noStore(resp *http.Response) bool {
+	for _, value := range resp.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setValidators sets the conditional request headers for the validators
+// that aren't empty.
+func setValidators(header http.Header, etag, lastModified string) {
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// conditional reports whether the request headers make it conditional on
+// the caller's copy being outdated.
+func conditional(header http.Header) bool {
+	return header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != ""
+}
@@ -0,0 +1,30 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// canonicalJSON re-encodes the JSON body with object keys sorted and without
+// insignificant whitespace. Numbers are kept as they are written, strings are
+// escaped the way encoding/json does it, of duplicate keys the last one wins.
+func canonicalJSON(body []byte) (*bytes.Buffer, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var js interface{}
+	if err := dec.Decode(&js); err != nil {
+		return nil, err
+	}
+
+	buffer := new(bytes.Buffer)
+	enc := json.NewEncoder(buffer)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(js); err != nil {
+		return nil, err
+	}
+
+	// Drop the newline the encoder terminates every value with.
+	buffer.Truncate(buffer.Len() - 1)
+	return buffer, nil
+}
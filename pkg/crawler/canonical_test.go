@@ -0,0 +1,30 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCanonicalJSONReorderedKeys(t *testing.T) {
+	first := newJSONServer(t, `{"b": 2, "a": {"y": [1, 2], "x": "<&>"}, "c": 1.50}`)
+	second := newJSONServer(t, `{"c":1.50,"a":{"x":"<&>","y":[1,2]},"b":2}`)
+
+	cr := newTestCrawler(t, Config{CanonicalJSON: true})
+	results, err := cr.Crawl(context.Background(), []string{first.URL, second.URL})
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+
+	want := `{"a":{"x":"<&>","y":[1,2]},"b":2,"c":1.50}`
+	for _, res := range results {
+		if got := string(res.ResponseBody); got != want {
+			t.Errorf("%s: ResponseBody = %s, want %s", res.SourceURL, got, want)
+		}
+	}
+}
+
+func TestCanonicalJSONInvalid(t *testing.T) {
+	if _, err := canonicalJSON([]byte(`{"a":`)); err == nil {
+		t.Error("canonicalJSON() error = nil, want one for invalid JSON")
+	}
+}
@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// windows1252 maps the 0x80-0x9F range of Windows-1252 to Unicode,
+// the rest of the code page matches ISO-8859-1.
+var windows1252 = [32]rune{
+	'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+	utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+}
+
+// decoders lists supported charsets by their lowercased IANA names and aliases.
+var decoders = map[string]func([]byte) []byte{
+	"utf-8":        nil,
+	"utf8":         nil,
+	"us-ascii":     nil,
+	"ascii":        nil,
+	"iso-8859-1":   decodeLatin1,
+	"iso8859-1":    decodeLatin1,
+	"latin1":       decodeLatin1,
+	"l1":           decodeLatin1,
+	"windows-1252": decodeWindows1252,
+	"cp1252":       decodeWindows1252,
+}
+
+// decodeCharset converts the body to UTF-8 according to the charset
+// declared in the Content-Type header. Bodies without a charset are
+// expected to be UTF-8 already and are returned as is.
+func decodeCharset(contentType string, body []byte) ([]byte, error) {
+	if contentType == "" {
+		return body, nil
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("parse content type %q: %w", contentType, err)
+	}
+	charset, ok := params["charset"]
+	if !ok {
+		return body, nil
+	}
+	decode, ok := decoders[strings.ToLower(charset)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported charset: %q", charset)
+	}
+	if decode == nil {
+		return body, nil
+	}
+	return decode(body), nil
+}
+
+// decodeLatin1 converts ISO-8859-1 encoded bytes to UTF-8.
+func decodeLatin1(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	for _, b := range body {
+		out = append(out, string(rune(b))...)
+	}
+	return out
+}
+
+// decodeWindows1252 converts Windows-1252 encoded bytes to UTF-8.
+func decodeWindows1252(body []byte) []byte {
+	out := make([]byte, 0, len(body))
+	for _, b := range body {
+		r := rune(b)
+		if b >= 0x80 && b <= 0x9F {
+			r = windows1252[b-0x80]
+		}
+		out = append(out, string(r)...)
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeCharsetLatin1Body(t *testing.T) {
+	// {"name":"Café"} with é as the single ISO-8859-1 byte 0xE9.
+	latin1 := []byte("{\"name\":\"Caf\xe9\"}")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=ISO-8859-1")
+		_, _ = w.Write(latin1)
+	}))
+	defer srv.Close()
+
+	cr := newTestCrawler(t, Config{DecodeCharset: true})
+	results, err := cr.Crawl(context.Background(), []string{srv.URL})
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if got, want := string(results[0].ResponseBody), `{"name":"Café"}`; got != want {
+		t.Errorf("ResponseBody = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeCharset(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+		wantErr     bool
+	}{
+		{contentType: "", body: "\"\xe9\"", want: "\"\xe9\""},
+		{contentType: "application/json", body: `"é"`, want: `"é"`},
+		{contentType: "application/json; charset=utf-8", body: `"é"`, want: `"é"`},
+		{contentType: "application/json; charset=latin1", body: "\"\xe9\"", want: `"é"`},
+		{contentType: "application/json; charset=windows-1252", body: "\"\x80\"", want: `"€"`},
+		{contentType: "application/json; charset=koi8-r", body: `""`, wantErr: true},
+		{contentType: "application/json; charset=", body: `""`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := decodeCharset(tt.contentType, []byte(tt.body))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("decodeCharset(%q) error = nil, want one", tt.contentType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decodeCharset(%q) error = %v", tt.contentType, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("decodeCharset(%q) = %q, want %q", tt.contentType, got, tt.want)
+		}
+	}
+}
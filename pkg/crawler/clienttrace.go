@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+)
+
+// exchange collects low-level details of a single request as it is sent.
+type exchange struct {
+	headerBytes int64     // Size of the serialized header fields.
+	ages        *connAges // Tracks the age of connections, nil if it is not limited.
+	stats       *stats    // Counts connection reuse, nil if it is not counted.
+
+	sync.Mutex
+	conn *agedConn // Connection the request is sent over.
+}
+
+// withClientTrace attaches hooks that fill in the exchange to the request.
+func withClientTrace(req *http.Request, ex *exchange) *http.Request {
+	trace := &httptrace.ClientTrace{
+		WroteHeaderField: func(key string, values []string) {
+			for _, value := range values {
+				// The field is written as "Key: value\r\n".
+				atomic.AddInt64(&ex.headerBytes, int64(len(key)+len(value)+4))
+			}
+		},
+	}
+	if ex.ages != nil || ex.stats != nil {
+		trace.GotConn = func(info httptrace.GotConnInfo) {
+			if ex.stats != nil {
+				ex.stats.countConn(info.Reused)
+			}
+			if ex.ages == nil {
+				return
+			}
+			if ac, ok := ex.ages.lookup(info.Conn); ok {
+				ex.Lock()
+				ex.conn = ac
+				ex.Unlock()
+				ac.setIdle(false)
+			}
+		}
+	}
+	if ex.ages != nil {
+		// PutIdleConn doesn't tell the connection, the one of GotConn is meant.
+		trace.PutIdleConn = func(err error) {
+			ex.Lock()
+			ac := ex.conn
+			ex.Unlock()
+			if ac != nil && err == nil {
+				ac.setIdle(true)
+			}
+		}
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// requestBytes estimates how many bytes of the request were sent: the request
+// line, the header fields reported by the transport and the body. For HTTP/2
+// the headers are counted before compression. Zero means nothing was sent.
+func (ex *exchange) requestBytes(req *http.Request) int64 {
+	headerBytes := atomic.LoadInt64(&ex.headerBytes)
+	if headerBytes == 0 {
+		return 0
+	}
+
+	// The request line is "METHOD URI HTTP/1.1\r\n", headers end with "\r\n".
+	size := int64(len(req.Method)+len(" ")+len(req.URL.RequestURI())+len(" HTTP/1.1\r\n")+len("\r\n")) + headerBytes
+	if req.ContentLength > 0 {
+		size += req.ContentLength
+	}
+	return size
+}
@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+type (
+	// dialFunc is the signature of http.Transport.DialContext.
+	dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// connAges retires connections older than maxAge: an expired connection
+	// is closed as soon as it is back in the idle pool, so it is never reused.
+	connAges struct {
+		clock  clock.Clock // Measures maxAge.
+		maxAge time.Duration
+		conns  sync.Map // Connection key to *agedConn.
+	}
+	agedConn struct {
+		net.Conn
+		sync.Mutex
+		ages    *connAges
+		key     string
+		closed  chan struct{} // Stops the expiry timer.
+		once    sync.Once
+		idle    bool
+		expired bool
+	}
+)
+
+// Interface compliance check.
+var _ net.Conn = (*agedConn)(nil)
+
+// dial wraps the dial function to keep track of the age of new connections.
+func (ca *connAges) dial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		ac := &agedConn{Conn: conn, ages: ca, key: connKey(conn), closed: make(chan struct{})}
+		ca.conns.Store(ac.key, ac)
+		go ac.expireAfter(ca.clock.NewTimer(ca.maxAge))
+		return ac, nil
+	}
+}
+
+// lookup returns the tracked connection under conn, which may also be
+// a TLS connection on top of it.
+func (ca *connAges) lookup(conn net.Conn) (*agedConn, bool) {
+	if ac, ok := conn.(*agedConn); ok {
+		return ac, true
+	}
+	ac, ok := ca.conns.Load(connKey(conn))
+	if !ok {
+		return nil, false
+	}
+	return ac.(*agedConn), true
+}
+
+// connKey identifies a connection by its addresses, they are shared by
+// a TLS connection and the one it runs on.
+func connKey(conn net.Conn) string {
+	return conn.LocalAddr().String() + "->" + conn.RemoteAddr().String()
+}
+
+// setIdle records whether the connection waits in the idle pool, an expired
+// one is closed once it gets there.
+func (ac *agedConn) setIdle(idle bool) {
+	ac.Lock()
+	ac.idle = idle
+	closing := idle && ac.expired
+	ac.Unlock()
+
+	if closing {
+		_ = ac.Close()
+	}
+}
+
+// expireAfter expires the connection once the timer fires, unless it is
+// closed before.
+func (ac *agedConn) expireAfter(timer clock.Timer) {
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		ac.expire()
+	case <-ac.closed:
+	}
+}
+
+// expire marks the connection as too old to be reused.
+func (ac *agedConn) expire() {
+	ac.Lock()
+	ac.expired = true
+	closing := ac.idle
+	ac.Unlock()
+
+	if closing {
+		_ = ac.Close()
+	}
+}
+
+// Close closes the connection and stops tracking it.
+func (ac *agedConn) Close() (err error) {
+	ac.once.Do(func() {
+		close(ac.closed)
+		ac.ages.conns.Delete(ac.key)
+		err = ac.Conn.Close()
+	})
+	return err
+}
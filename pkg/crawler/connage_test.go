@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+func TestMaxConnAgeUsesClock(t *testing.T) {
+	var dials int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&dials, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	fake := clock.NewFake(time.Unix(0, 0))
+	cr := newTestCrawler(t, Config{MaxConnAge: time.Minute, Clock: fake})
+
+	crawl := func() {
+		t.Helper()
+		if _, err := cr.Crawl(context.Background(), []string{srv.URL}); err != nil {
+			t.Fatalf("Crawl() error = %v", err)
+		}
+	}
+
+	crawl()
+	crawl()
+	if got := atomic.LoadInt32(&dials); got != 1 {
+		t.Fatalf("before MaxConnAge: %d connections, want 1", got)
+	}
+
+	fake.Advance(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for tracked(cr.ages) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired connection still open")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	crawl()
+	if got := atomic.LoadInt32(&dials); got != 2 {
+		t.Errorf("after MaxConnAge: %d connections, want 2", got)
+	}
+}
+
+// tracked counts the connections whose age is tracked.
+func tracked(ca *connAges) int {
+	var n int
+	ca.conns.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -0,0 +1,30 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+)
+
+// cookieJarKey is the context key of the cookie jar of a batch.
+type cookieJarKey struct{}
+
+// withCookieJar returns ctx carrying a new cookie jar for the batch.
+func withCookieJar(ctx context.Context) context.Context {
+	// The jar only fails with invalid options.
+	jar, _ := cookiejar.New(nil)
+	return context.WithValue(ctx, cookieJarKey{}, jar)
+}
+
+// batchClient returns the client to send the requests of the batch with:
+// the shared one, or a copy of it with the cookie jar of the batch. Copies
+// share the transport, and with it the connections.
+func (cr *crawler) batchClient(ctx context.Context) *http.Client {
+	jar, ok := ctx.Value(cookieJarKey{}).(http.CookieJar)
+	if !ok {
+		return cr.client
+	}
+	client := *cr.client
+	client.Jar = jar
+	return &client
+}
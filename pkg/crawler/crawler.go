@@ -3,34 +3,366 @@ package crawler
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
 )
 
 type (
 	Crawler interface {
 		Crawl(ctx context.Context, urls []string) ([]Result, error)
+		CrawlPrioritized(ctx context.Context, urls []PriorityURL) ([]Result, error)
+		CrawlBatch(ctx context.Context, requests []Request) ([]Result, error)
+		CrawlRequests(ctx context.Context, reqs []*http.Request) ([]Result, error)
+		CrawlStream(ctx context.Context, urls []string) (<-chan Result, error)
+		ValidateURLs(urls []string) []error
+		PurgeCache()
+		Warm(ctx context.Context, hosts []string)
+		CloseIdleConnections()
+		Close() error
+		Stats() CrawlerStats
+		UpdateHostPolicy(policy HostPolicy)
+	}
+	PriorityURL struct {
+		URL      string
+		Priority int // URLs with higher priority are sent first.
+	}
+	Request struct {
+		URL      string
+		Method   string      // Config.Method if empty.
+		Body     []byte      // Sent as application/json if not empty.
+		Header   http.Header // Replaces the values of Config.Headers it has.
+		Priority int         // Requests with higher priority are sent first.
 	}
 	Result struct {
 		SourceURL    string
-		StatusCode   int
+		FinalURL     string      // URL the response came from, after redirects if any were followed.
+		StatusCode   int         // Also set on results failed with a status that AcceptStatus rejected.
+		Headers      http.Header // Of the response, nil if none was received, with sensitive ones redacted.
 		ResponseBody json.RawMessage
+		BodyPath     string // Set instead of ResponseBody when the body was spilled to disk.
+		RequestBytes int64  // Size of the sent request: request line, headers and body.
+		BytesRead    int64  // Size of the response body as received, before decompression.
+		Truncated    bool   // Set if only the complete elements of a truncated body are kept.
+
+		// NotModified is set if a conditional request was answered with 304
+		// Not Modified: the result has no body, the caller's copy is current.
+		NotModified bool
+
+		// Duration is the time from sending the request to having read and
+		// checked the response, of the last attempt if it was retried.
+		Duration time.Duration
 
-		err error
+		// ContentEncoding is set with RawBody: the Content-Encoding
+		// of the response, "identity" if there was none. ResponseBody then
+		// holds the bytes as they were received. Bodies of responses that
+		// AcceptStatus rejected or ResponseValidator passed are flagged
+		// "identity" if they aren't JSON.
+		ContentEncoding string
+
+		index   int  // Position of the URL in the batch.
+		noStore bool // Set if the response asked not to be cached.
+		err     error
 	}
 	Config struct {
-		MaxConnections uint16        // Number of simultaneous requests.
+		MaxConnections uint16        // Number of simultaneous requests, zero means one per URL of the batch.
 		RequestTimeout time.Duration // Timeout per request.
+		DecodeCharset  bool          // Convert bodies to UTF-8 using the Content-Type charset.
+		SpillDir       string        // Directory to spill response bodies to, empty keeps them in memory.
+		Method         string        // Method of requests that don't set one, GET if empty.
+		Headers        http.Header   // Sent with every request, a User-Agent there wins over UserAgent.
+		UserAgent      string        // Sent unless a batch or the headers set another one, multiplexer/1.0 if empty.
+		MaxBodySize    int64         // Bodies larger than this fail with ErrBodyTooLarge, zero means no limit.
+
+		// SlowRequestThreshold logs requests that take longer than that,
+		// at LogLevelInfo, to catch slow upstreams before they time out.
+		// Zero logs none.
+		SlowRequestThreshold time.Duration
+
+		// ResponseValidator replaces the check that bodies are JSON, an
+		// error fails the URL. Bodies that pass it are kept verbatim: they
+		// are neither compacted nor canonicalized, and those that aren't
+		// JSON are flagged with ContentEncoding "identity". Nil validates
+		// and compacts JSON. SalvageTruncated only works with the default.
+		ResponseValidator func(body []byte) error
+
+		// ConditionalFrom returns the validators of a URL's copy the caller
+		// already has, sent as If-None-Match and If-Modified-Since unless
+		// empty. When either of them is sent, a 304 Not Modified response is
+		// a success flagged NotModified. Request headers replace both.
+		ConditionalFrom func(url string) (etag, lastModified string)
+
+		// AcceptStatus reports whether a response status counts as success,
+		// nil accepts 200 only. Results of rejected responses fail with
+		// their status code and keep the body for inspection.
+		AcceptStatus func(code int) bool
+
+		// CanonicalJSON sorts object keys of response bodies, so that the
+		// same data gives the same bytes whatever order the upstream sends
+		// the keys in. It costs a full decode and encode of every body.
+		CanonicalJSON bool
+
+		// StrictJSON fails bodies with an object that has a duplicate key,
+		// which the default check lets pass with the last one winning, and
+		// tells where it is. Data after the first value fails either way.
+		// Like SalvageTruncated, it only works with the default
+		// ResponseValidator.
+		StrictJSON bool
+
+		// RawBody turns off decompression, validation and compaction of
+		// response bodies: they are returned byte for byte as the upstream
+		// sent them, so NDJSON, CSV or HTML arrive verbatim. Requests get
+		// no Accept-Encoding of their own, set one in Headers to receive
+		// compressed bytes as they are.
+		RawBody bool
+
+		// SalvageTruncated keeps the complete elements of bodies that are
+		// cut short, as long as they are JSON arrays or NDJSON, and flags
+		// the results as truncated. NDJSON bodies are returned as arrays of
+		// their values, whether they are cut short or not.
+		SalvageTruncated bool
+
+		// DiscardBody drops response bodies once they are validated, only
+		// the status is returned, which is enough for liveness checks of
+		// many endpoints.
+		DiscardBody bool
+
+		// Transport settings, all of the limits are derived from
+		// MaxConnections when unset. IdleConnTimeout defaults to
+		// the one of http.DefaultTransport.
+		DisableKeepAlives   bool
+		MaxIdleConns        int
+		MaxIdleConnsPerHost int
+		MaxConnsPerHost     int
+		IdleConnTimeout     time.Duration
+
+		// ForceHTTP1 keeps HTTPS requests on HTTP/1.1 for upstreams that
+		// misbehave over HTTP/2. TLSConfig replaces the TLS settings of
+		// the transport, to pin certificates or trust a private CA.
+		ForceHTTP1 bool
+		TLSConfig  *tls.Config
+
+		// MaxConnAge keeps HTTP/1 connections from being reused once they
+		// are older than that, zero means no limit. Long-lived connections
+		// are more likely to have been dropped by the upstream silently.
+		MaxConnAge time.Duration
+
+		// CountConnReuse counts requests sent over new and reused
+		// connections in Stats, telling whether the idle pool limits
+		// suit the workload.
+		CountConnReuse bool
+
+		// SerializePerHost sends requests to the same host one at a time,
+		// in priority and then submission order, while different hosts are
+		// still crawled in parallel. A batch then takes at least as long as
+		// the sum of response times of its busiest host, and no more than
+		// min(MaxConnections, number of hosts) requests are in flight.
+		SerializePerHost bool
+
+		// MaxPerHost limits the number of simultaneous requests to the same
+		// host within a batch, the same way SerializePerHost does for one.
+		// Zero means no limit other than MaxConnections.
+		MaxPerHost uint16
+
+		// StatusOnReadError keeps the status code on results whose body
+		// could not be read to the end, telling a server that responded
+		// and then broke the connection from one that never responded.
+		StatusOnReadError bool
+
+		// MaxRetries is the number of times a failed request is sent again.
+		// Requests that couldn't be sent, responses with a 5xx status and
+		// responses with a JSON body that RetryIfJSON reports as an error
+		// are retried, the latter suits APIs that answer with 200 and
+		// something like {"error":"rate_limited"}. RetryIfJSON is only
+		// called with MaxRetries set. Other 4xx responses aren't retried.
+		// Requests are retried whatever their method, POST included.
+		MaxRetries  uint8
+		RetryIfJSON func(body json.RawMessage) bool
+
+		// RetryBackoff is the longest pause before the first retry, doubled
+		// with every next one up to a minute. Every pause is a random time
+		// up to that. Zero retries right away.
+		RetryBackoff time.Duration
+
+		// FairQueuing sends URLs of the same priority round-robin by host
+		// instead of in submission order, so that a host with many URLs in
+		// the batch doesn't hold up the others.
+		FairQueuing bool
+
+		// Deduplicate sends identical requests of a batch once: same URL,
+		// method, body and headers. Their result is returned at the place
+		// of every one of them, sharing the body.
+		Deduplicate bool
+
+		// HostQPS limits requests per second to each host, HostQPSOverrides
+		// sets the limit of single hosts, as in the URL with the port if any,
+		// and zero there exempts a host. HostBurst requests may go out at
+		// once after a host was idle, zero means one. The limits hold across
+		// batches. A request waiting for its host takes up one of the
+		// MaxConnections, but the wait doesn't count towards RequestTimeout.
+		HostQPS          float64
+		HostQPSOverrides map[string]float64
+		HostBurst        int
+
+		// RequestsPerSecond limits the requests of the crawler to all hosts
+		// together, evenly spaced out, zero means no limit. It is waited
+		// for after the limit of the host, in the same way.
+		RequestsPerSecond float64
+
+		// HostPolicy is the initial host policy, UpdateHostPolicy replaces
+		// it at runtime. URLs of hosts it doesn't allow fail validation
+		// with ErrHostNotAllowed.
+		HostPolicy HostPolicy
+
+		// Credentials authenticate the requests to hosts, as in the URL with
+		// the port if any, and entries without a port match any port. They
+		// win over an Authorization header in Headers, but not over one of
+		// a single request. Hosts without an entry get no credentials.
+		Credentials map[string]Credential
+
+		// CacheTTL keeps successful results of GET requests for that long,
+		// and requests for the same URL with the same headers get them
+		// instead of being sent, zero disables the cache. Up to CacheSize
+		// results are kept, 1000 if it is zero, the least recently used
+		// are dropped first. Responses with Cache-Control: no-store and
+		// spilled bodies aren't cached. PurgeCache drops all of them.
+		CacheTTL  time.Duration
+		CacheSize int
+
+		// EnableCookieJar keeps the cookies that responses set for the
+		// rest of the batch, redirects included, so that endpoints with a
+		// session cookie work. Every batch starts with an empty jar. It
+		// makes requests to the same host depend on each other: whether a
+		// request gets a cookie depends on which responses came first, so
+		// send the one that sets it with a higher priority and
+		// SerializePerHost.
+		EnableCookieJar bool
+
+		// MaxRedirects is the number of redirects followed, zero follows
+		// none: the 3xx response is then the result, failed unless
+		// AcceptStatus accepts it. DefaultConfig follows 10.
+		MaxRedirects int
+
+		// AllowedSchemes are the URL schemes that pass validation, nil
+		// means http and https.
+		AllowedSchemes []string
+
+		// BlockPrivateNetworks fails batches with URLs of hosts that are,
+		// or resolve to, loopback, link-local or private network addresses
+		// with ErrPrivateNetwork. Connections to such addresses are refused
+		// as well, which covers redirects and proxies, unless the client
+		// was passed to NewWithClient.
+		BlockPrivateNetworks bool
+
+		// MaxConcurrentDNS is the number of hosts resolved at the same time
+		// across all requests, so that batches of many distinct hosts don't
+		// overwhelm the resolver. DefaultConfig resolves 16 at a time, zero
+		// means unlimited and leaves resolution to net.Dialer.
+		MaxConcurrentDNS int
+
+		// BatchTimeout limits the whole batch, zero means no limit. When it
+		// runs out, requests in flight are canceled and the results done so
+		// far are returned with an error wrapping context.DeadlineExceeded.
+		// With ContinueOnError, the canceled requests are among them as
+		// failed results, URLs that weren't sent yet are left out.
+		BatchTimeout time.Duration
+
+		// By default a batch is aborted on the first failure. With any of
+		// the thresholds set, failed URLs are left out of the results and
+		// the batch is aborted once there are more than MaxFailures of
+		// them or they exceed MaxFailureRatio of all URLs.
+		MaxFailures     int
+		MaxFailureRatio float64
+
+		// FinishOnError keeps a batch aborted on failures from canceling
+		// the other requests: all of them are sent and run to completion,
+		// each limited by its own RequestTimeout, and the error is returned
+		// once they are done. It suits requests with side effects that
+		// shouldn't be cut off halfway.
+		FinishOnError bool
+
+		// ContinueOnError never aborts a batch on failures: every URL is
+		// crawled and failed ones are returned among the results, with
+		// their error on Result.Err, and the batch returns CrawlErrors
+		// with all of them. The thresholds are then ignored, only the
+		// caller's context and BatchTimeout stop a batch early. A batch
+		// stopped by its context returns the results it has so far, the
+		// canceled requests failed among them, with the context's error.
+		ContinueOnError bool
+
+		// LogBodies logs requests and responses of failed URLs, bodies are
+		// cut to LogBodyMaxBytes, zero logs them in full. Values of the
+		// DefaultRedactHeaders and of RedactHeaders, which adds to them,
+		// are replaced with RedactedValue wherever headers are logged or
+		// returned.
+		LogBodies       bool
+		LogBodyMaxBytes int64
+		RedactHeaders   []string
+
+		// LogLevel limits the log output, per-request messages are logged
+		// at LogLevelDebug. With LogBodies set, debug level logs bodies of
+		// all URLs, not just the failed ones. Logger gets the messages,
+		// nil logs them with the standard logger of the log package.
+		LogLevel LogLevel
+		Logger   Logger
+
+		Clock  clock.Clock // Measures RequestTimeout, nil means the system clock.
+		Tracer Tracer      // Traces batches and requests, nil disables tracing.
+
+		// StartSpan is a lighter alternative to Tracer, called for every
+		// request sent, retries included. The request is sent with the
+		// returned context, so that a tracing transport continues the span,
+		// and finish is called with the StatusCode and the error of the result.
+		StartSpan func(ctx context.Context, url string) (_ context.Context, finish func(status int, err error))
+
+		// Transform is called for every successful result and returns the
+		// one to keep, an error fails the URL. With TransformWorkers set,
+		// up to that many results are transformed at the same time apart
+		// from the crawl workers, otherwise every crawl worker transforms
+		// its own results. Results keep their order either way.
+		Transform        func(res Result) (Result, error)
+		TransformWorkers int
+
+		// OnProgress is called each time a URL of the batch is done, failed
+		// ones included. Calls come one at a time from the goroutine that
+		// collects the results, so a slow callback holds up the batch: hand
+		// the numbers over to other goroutines for anything but a quick update.
+		// URLs left unsent by a batch stopped early are reported done in
+		// a final call, so that the count always ends at the total.
+		OnProgress func(completed, total int)
+
+		// OnResult is called with every result as soon as its request is
+		// done, failed ones included and before Transform. Calls come from
+		// the crawl workers at the same time, so it must be safe for
+		// concurrent use, and a slow callback holds up the worker.
+		OnResult func(res Result)
 	}
 	crawler struct {
-		config Config       // Crawler settings.
-		client *http.Client // Reusable HTTP-client for outgoing requests.
+		config      Config       // Crawler settings.
+		client      *http.Client // Reusable HTTP-client for outgoing requests.
+		clock       clock.Clock
+		tracer      Tracer
+		logger      Logger
+		ages        *connAges             // Nil unless MaxConnAge is set.
+		rates       *hostRates            // Nil unless HostQPS or HostQPSOverrides are set.
+		requestRate *hostRates            // Nil unless RequestsPerSecond is set.
+		policy      atomic.Value          // HostPolicy, swapped as a whole by UpdateHostPolicy.
+		credentials map[string]Credential // Credentials by lowercased host.
+		cache       *responseCache        // Nil unless CacheTTL is set.
+		random      *lockedRand           // Jitter of retry backoffs.
+		stats       stats
 	}
 )
 
@@ -38,13 +370,43 @@ var (
 	// Interface compliance check.
 	_ Crawler = (*crawler)(nil)
 
+	// errRetryBody fails responses that match the RetryIfJSON condition.
+	errRetryBody = errors.New("response body matches the retry condition")
+
+	// errTooManyRedirects fails requests redirected more than MaxRedirects times.
+	errTooManyRedirects = errors.New("too many redirects")
+
+	// ErrFailureThreshold is returned along with the results gathered
+	// so far when a batch is aborted on too many failures.
+	ErrFailureThreshold = errors.New("abort on failure threshold")
+
+	// ErrHostNotAllowed is returned for batches with URLs of hosts that
+	// the host policy doesn't allow.
+	ErrHostNotAllowed = errors.New("host not allowed")
+
+	// ErrPrivateNetwork is returned for URLs of private network addresses
+	// with BlockPrivateNetworks set.
+	ErrPrivateNetwork = errors.New("private network address not allowed")
+
 	// defaultConfig stores predefined settings.
 	defaultConfig = Config{
-		MaxConnections: 4,
-		RequestTimeout: time.Second,
+		MaxConnections:   4,
+		RequestTimeout:   time.Second,
+		Method:           http.MethodGet,
+		MaxConcurrentDNS: 16,
+		MaxConnAge:       10 * time.Minute,
+		LogBodyMaxBytes:  1024,
+		AllowedSchemes:   defaultSchemes,
+		UserAgent:        defaultUserAgent,
+		MaxRedirects:     10,
 	}
 )
 
+// DefaultConfig returns predefined settings to build a custom configuration on.
+func DefaultConfig() Config {
+	return defaultConfig
+}
+
 // New returns a new instance of Crawler with default settings.
 func New() Crawler {
 	c, _ := NewWithConfig(defaultConfig)
@@ -53,196 +415,835 @@ func New() Crawler {
 
 // NewWithConfig returns a new instance of Crawler with custom settings.
 func NewWithConfig(cfg Config) (Crawler, error) {
+	return NewWithClient(cfg, nil)
+}
+
+// NewWithClient returns a new instance of Crawler that sends requests with
+// the client as it is, nil builds one from the config as NewWithConfig does.
+// MaxConnections still limits the number of workers, but the transport
+// settings, TLS and HTTP/2 included, MaxConnAge, MaxConcurrentDNS and the
+// RawBody compression switch are then up to the client, and so is following
+// redirects: the host policy is only checked on them if the client's
+// CheckRedirect does.
+func NewWithClient(cfg Config, client *http.Client) (Crawler, error) {
+	var tracer Tracer = noopTracer{}
+	if cfg.Tracer != nil {
+		tracer = cfg.Tracer
+	}
+	var logger Logger = log.Default()
+	if cfg.Logger != nil {
+		logger = cfg.Logger
+	}
+
+	// RequestTimeout is applied per request with the configured clock
+	// instead of the http.Client timeout.
+	c := clock.OrNew(cfg.Clock)
+	cr := &crawler{
+		config:      cfg,
+		client:      client,
+		clock:       c,
+		tracer:      tracer,
+		logger:      logger,
+		rates:       newHostRates(cfg, c),
+		requestRate: newRequestRate(cfg, c),
+		credentials: normalizeCredentials(cfg.Credentials),
+		cache:       newResponseCache(cfg),
+		random:      newLockedRand(c.Now().UnixNano()),
+	}
+	if client == nil {
+		cr.client, cr.ages = newClient(cfg, c)
+	}
+	cr.UpdateHostPolicy(cfg.HostPolicy)
+	return cr, nil
+}
+
+// newClient builds the client of the config along with the registry of
+// connection ages, nil if their age isn't limited, measured with c.
+func newClient(cfg Config, c clock.Clock) (*http.Client, *connAges) {
 	maxConnections := int(cfg.MaxConnections)
 	tr := http.DefaultTransport.(*http.Transport).Clone()
-	tr.MaxIdleConns = maxConnections
-	tr.MaxConnsPerHost = maxConnections
-	tr.MaxIdleConnsPerHost = maxConnections
+	tr.DisableKeepAlives = cfg.DisableKeepAlives
+	tr.DisableCompression = cfg.RawBody
+	tr.MaxIdleConns = orDefault(cfg.MaxIdleConns, maxConnections)
+	tr.MaxConnsPerHost = orDefault(cfg.MaxConnsPerHost, maxConnections)
+	tr.MaxIdleConnsPerHost = orDefault(cfg.MaxIdleConnsPerHost, maxConnections)
+	if cfg.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.TLSConfig != nil {
+		tr.TLSClientConfig = cfg.TLSConfig.Clone()
+	}
+	if cfg.ForceHTTP1 {
+		// A non-nil empty map disables HTTP/2.
+		tr.ForceAttemptHTTP2 = false
+		tr.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if cfg.MaxConcurrentDNS > 0 {
+		d := newDialer(cfg.MaxConcurrentDNS)
+		if cfg.BlockPrivateNetworks {
+			d.Control = refusePrivateNetworks
+		}
+		tr.DialContext = d.DialContext
+	} else if cfg.BlockPrivateNetworks {
+		d := newNetDialer()
+		d.Control = refusePrivateNetworks
+		tr.DialContext = d.DialContext
+	}
+	var ages *connAges
+	if cfg.MaxConnAge > 0 {
+		ages = &connAges{clock: c, maxAge: cfg.MaxConnAge}
+		tr.DialContext = ages.dial(tr.DialContext)
+	}
+	return &http.Client{Transport: tr, CheckRedirect: checkRedirect(cfg.MaxRedirects)}, ages
+}
 
-	return &crawler{
-		config: cfg,
-		client: &http.Client{
-			Timeout:   cfg.RequestTimeout,
-			Transport: tr,
-		},
-	}, nil
+// Err returns the reason the URL failed, nil for successful results. Only
+// batches crawled with ContinueOnError return failed results.
+func (r Result) Err() error {
+	return r.err
+}
+
+// setHeaders replaces the values of dst with the ones of src.
+func setHeaders(dst, src http.Header) {
+	for key, values := range src {
+		dst[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+}
+
+// validMethod reports whether the method is one of the standard HTTP methods.
+func validMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// orDefault returns value if it is set, otherwise the fallback.
+func orDefault(value, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
 }
 
 // Crawl loops through the given URLs list, tries to get a response from
 // each and return either a slice of results, or the first error if present.
+// With ContinueOnError, failed URLs are returned among the results instead,
+// along with CrawlErrors.
+// Results are in the order of the URLs, those left out don't leave gaps.
 func (cr *crawler) Crawl(ctx context.Context, urls []string) ([]Result, error) {
-	select {
-	case <-ctx.Done():
-		log.Println("crawler: exit on context done:", ctx.Err())
-		return nil, ctx.Err()
-	default:
+	prioritized := make([]PriorityURL, len(urls))
+	for i, u := range urls {
+		prioritized[i].URL = u
 	}
+	return cr.CrawlPrioritized(ctx, prioritized)
+}
 
-	if len(urls) == 0 {
-		return nil, nil
+// CrawlPrioritized does the same as Crawl, but dispatches URLs with higher
+// priority first. URLs of the same priority are sent in the given order.
+// The order is best-effort: it decides which URLs wait once all of the
+// workers are busy, but requests sent at the same time may complete in
+// any order, and retries don't go back to the queue.
+func (cr *crawler) CrawlPrioritized(ctx context.Context, urls []PriorityURL) ([]Result, error) {
+	requests := make([]Request, len(urls))
+	for i, u := range urls {
+		requests[i] = Request{URL: u.URL, Priority: u.Priority}
 	}
+	return cr.CrawlBatch(ctx, requests)
+}
 
-	log.Printf("crawler: received %d tasks: validating URL format\n", len(urls))
+// CrawlBatch does the same as CrawlPrioritized, with the method and the body
+// of every request set by the caller.
+func (cr *crawler) CrawlBatch(ctx context.Context, requests []Request) ([]Result, error) {
+	ctx, span := cr.tracer.Start(ctx, "crawler.crawl")
+	defer span.End()
 
-	var invalidURLErr error
-	tasks := make(chan string, len(urls))
-	for _, checkURL := range urls {
-		// Check general cases for invalid URLs.
-		// Unfortunately, cases like "http://invalidurl" successfully pass this check.
-		if uri, err := url.ParseRequestURI(checkURL); err != nil || uri.Host == "" || uri.Scheme == "" {
-			log.Println("crawler: invalid url:", checkURL)
-			invalidURLErr = fmt.Errorf("invalid url: %q", checkURL)
-			break
-		}
-		tasks <- checkURL
+	span.SetAttribute("crawler.urls", len(requests))
+	results, err := cr.crawlBatch(ctx, requests)
+	span.SetAttribute("crawler.results", len(results))
+	if err != nil {
+		span.RecordError(err)
 	}
-	close(tasks)
+	return results, err
+}
 
-	if invalidURLErr != nil {
-		return nil, invalidURLErr
+// CrawlRequests does the same as CrawlBatch with requests built by the
+// caller: their URL, method, headers and body are sent, the rest, context
+// and Host override included, is left behind. Bodies are read before the
+// batch starts, so that retries can send them again.
+func (cr *crawler) CrawlRequests(ctx context.Context, reqs []*http.Request) ([]Result, error) {
+	requests := make([]Request, len(reqs))
+	for i, req := range reqs {
+		if req == nil || req.URL == nil {
+			return nil, fmt.Errorf("invalid request %d: no url", i)
+		}
+		body, err := requestBody(req)
+		if err != nil {
+			return nil, fmt.Errorf("read a request body: %q: %w", req.URL, err)
+		}
+		requests[i] = Request{URL: req.URL.String(), Method: req.Method, Body: body, Header: req.Header.Clone()}
 	}
+	return cr.CrawlBatch(ctx, requests)
+}
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	// Given condition: limit the number of outgoing requests.
-	numWorkers := int(cr.config.MaxConnections)
-	if numWorkers > len(urls) {
-		numWorkers = len(urls)
+// requestBody reads the body of the request, leaving it to be read again
+// if the request can give another copy of it.
+func requestBody(req *http.Request) ([]byte, error) {
+	body := req.Body
+	if req.GetBody != nil {
+		var err error
+		if body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
 	}
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
 
-	results := make(chan Result)
-	wg := &sync.WaitGroup{}
-	wg.Add(numWorkers)
+// CrawlStream crawls the URLs as Crawl does, but sends every result on the
+// returned channel as soon as it is done, in no particular order. Failed
+// URLs are sent too, with their error on Result.Err, and never stop the
+// batch: the failure thresholds don't apply, only ctx and BatchTimeout
+// stop it early, and URLs that weren't sent by then are left out. The
+// channel is closed once the batch is done. It has to be read to the end
+// unless ctx is done: results nobody reads after that are discarded.
+func (cr *crawler) CrawlStream(ctx context.Context, urls []string) (<-chan Result, error) {
+	requests := make([]Request, len(urls))
+	for i, u := range urls {
+		requests[i].URL = u
+	}
 
-	log.Printf("crawler: starting %d workers\n", numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		go cr.worker(ctx, wg, tasks, results)
+	ctx, span := cr.tracer.Start(ctx, "crawler.crawl")
+	span.SetAttribute("crawler.urls", len(requests))
+	b, err := cr.startBatch(ctx, requests)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
 	}
 
+	out := make(chan Result)
 	go func() {
-		wg.Wait()
-		close(results)
-		log.Println("crawler: results channel closed")
+		defer span.End()
+		defer close(out)
+		defer b.cancel()
+
+		var completed int
+		for res := range b.results {
+			if completed++; cr.config.OnProgress != nil {
+				cr.config.OnProgress(completed, len(requests))
+			}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				cr.debugln("crawler: stream abandoned: discarding result:", res.SourceURL)
+				cr.cleanup([]Result{res})
+			}
+		}
+		cr.progressDone(completed, len(requests))
+		span.SetAttribute("crawler.results", completed)
 	}()
+	return out, nil
+}
 
+// crawlBatch runs the batch for CrawlBatch.
+func (cr *crawler) crawlBatch(ctx context.Context, requests []Request) ([]Result, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+
+	b, err := cr.startBatch(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+	defer b.cancel()
+
+	// Results are put at the index of their URL, whatever order they come in.
 	var exitErr error
-	out := make([]Result, 0, len(urls))
-	for res := range results {
+	var failures, completed, kept int
+	var failed CrawlErrors
+	out := make([]Result, len(requests))
+	keep := func(res Result) {
+		out[res.index] = res
+		kept++
+	}
+	for res := range b.results {
+		if completed++; cr.config.OnProgress != nil {
+			cr.config.OnProgress(completed, len(requests))
+		}
 		if exitErr != nil {
-			log.Println("crawler: error occurred: skipping new results")
+			cr.debugln("crawler: error occurred: skipping new results")
+			cr.cleanup([]Result{res})
+			continue
+		}
+		if res.err != nil && cr.config.ContinueOnError {
+			cr.infof("crawler: error occurred: keeping failed result: %s: %s\n", res.SourceURL, res.err)
+			failed = append(failed, &CrawlError{Index: res.index, URL: res.SourceURL, Err: res.err})
+			keep(res)
 			continue
 		}
 		if res.err != nil {
-			log.Println("crawler: error occurred: stopping other goroutines")
-			exitErr = fmt.Errorf("failed to crawl %q: %w", res.SourceURL, res.err)
-			cancel()
+			// Failed results are dropped, along with the bodies of rejected responses.
+			cr.cleanup([]Result{res})
+			if b.budgetExceeded() {
+				cr.debugln("crawler: batch timeout: skipping failed result:", res.err)
+				continue
+			}
+			failures++
+			crawlErr := fmt.Errorf("failed to crawl %q: %w", res.SourceURL, res.err)
+			if !cr.tooManyFailures(failures, len(requests)) {
+				cr.infof("crawler: error occurred: tolerating failure %d: %s\n", failures, crawlErr)
+				continue
+			}
+			exitErr = crawlErr
+			if cr.hasFailureThreshold() {
+				exitErr = fmt.Errorf("%w: %d of %d URLs failed: %s", ErrFailureThreshold, failures, len(requests), crawlErr)
+			}
+			if cr.config.FinishOnError {
+				cr.infoln("crawler: error occurred: waiting for other goroutines")
+				continue
+			}
+			cr.infoln("crawler: error occurred: stopping other goroutines")
+			b.cancel()
 			continue
 		}
-		log.Println("crawler: received new result")
-		out = append(out, res)
+		cr.debugln("crawler: received new result")
+		keep(res)
+	}
+	cr.progressDone(completed, len(requests))
+	if kept < len(requests) {
+		out = compactResults(out)
 	}
 
 	if exitErr != nil {
-		log.Println("crawler: exit with error:", exitErr)
+		cr.errorln("crawler: exit with error:", exitErr)
+		if cr.hasFailureThreshold() {
+			return out, exitErr
+		}
+		cr.cleanup(out)
 		return nil, exitErr
 	}
 
-	log.Println("crawler: all tasks done")
+	// Keep what was crawled before the caller gave up, workers stopped on ctx.
+	if cr.config.ContinueOnError && ctx.Err() != nil && (len(out) < len(requests) || len(failed) > 0) {
+		cancelErr := fmt.Errorf("crawl stopped: %w: %d of %d URLs done", ctx.Err(), len(out), len(requests))
+		cr.errorln("crawler: exit with partial results:", cancelErr)
+		return out, cancelErr
+	}
+
+	if len(out) < len(requests) && b.budgetExceeded() {
+		timeoutErr := fmt.Errorf("%w: batch timeout of %s: %d of %d URLs done",
+			context.DeadlineExceeded, cr.config.BatchTimeout, len(out), len(requests))
+		cr.errorln("crawler: exit with partial results:", timeoutErr)
+		return out, timeoutErr
+	}
+
+	if len(failed) > 0 {
+		// Failures come in the order they happen, not the one of the batch.
+		sort.Slice(failed, func(i, j int) bool { return failed[i].Index < failed[j].Index })
+		cr.infof("crawler: all tasks done: %d URLs failed\n", len(failed))
+		return out, failed
+	}
+
+	cr.infoln("crawler: all tasks done")
 	return out, nil
 }
 
+// progressDone reports the URLs the batch left unsent as done, once all of
+// the results are collected.
+func (cr *crawler) progressDone(completed, total int) {
+	if completed < total && cr.config.OnProgress != nil {
+		cr.config.OnProgress(total, total)
+	}
+}
+
+// compactResults drops the slots of URLs without a result, keeping the order.
+func compactResults(slots []Result) []Result {
+	out := slots[:0]
+	for _, res := range slots {
+		if res.SourceURL != "" {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// maxPerHost returns the number of requests that may go to the same host
+// at a time, zero if it isn't limited.
+func (cr *crawler) maxPerHost() int {
+	if cr.config.SerializePerHost {
+		return 1
+	}
+	return int(cr.config.MaxPerHost)
+}
+
+// hasFailureThreshold reports whether the batch tolerates some failures.
+func (cr *crawler) hasFailureThreshold() bool {
+	return cr.config.MaxFailures > 0 || cr.config.MaxFailureRatio > 0
+}
+
+// tooManyFailures reports whether the batch has to be aborted after
+// the given number of failed URLs out of total.
+func (cr *crawler) tooManyFailures(failures, total int) bool {
+	if !cr.hasFailureThreshold() {
+		return true
+	}
+	if cr.config.MaxFailures > 0 && failures > cr.config.MaxFailures {
+		return true
+	}
+	return cr.config.MaxFailureRatio > 0 && float64(failures)/float64(total) > cr.config.MaxFailureRatio
+}
+
+// Warm pre-dials the given hosts so that their connections are waiting in the
+// idle pool by the time the first real request arrives. A host is either a bare
+// "host[:port]", which is dialed over HTTPS, or a URL with a scheme. Failures are
+// only logged. Warmed connections are subject to the transport's IdleConnTimeout
+// and get closed if nothing reuses them in time.
+func (cr *crawler) Warm(ctx context.Context, hosts []string) {
+	wg := &sync.WaitGroup{}
+	wg.Add(len(hosts))
+	for _, host := range hosts {
+		go func(host string) {
+			defer wg.Done()
+			cr.warm(ctx, host)
+		}(host)
+	}
+	wg.Wait()
+}
+
+// withTimeout returns ctx limited by RequestTimeout, if set.
+func (cr *crawler) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cr.config.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return clock.WithTimeout(ctx, cr.clock, cr.config.RequestTimeout)
+}
+
+// warm sends a HEAD request to the host and releases the connection back to the pool.
+func (cr *crawler) warm(ctx context.Context, host string) {
+	target := host
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	req, err := http.NewRequest(http.MethodHead, target, nil)
+	if err != nil {
+		cr.errorf("crawler: warm up %s: %s\n", host, err.Error())
+		return
+	}
+
+	ctx, cancel := cr.withTimeout(ctx)
+	defer cancel()
+
+	resp, err := cr.client.Do(withClientTrace(req.WithContext(ctx), &exchange{ages: cr.ages}))
+	if err != nil {
+		cr.errorf("crawler: warm up %s: %s\n", host, err.Error())
+		return
+	}
+
+	// Drain the body so the connection can be reused.
+	if _, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
+		cr.errorf("crawler: warm up %s: drain response body: %s\n", host, err.Error())
+	}
+	if err = resp.Body.Close(); err != nil {
+		cr.errorln("crawler: close response body:", err)
+	}
+	cr.debugf("crawler: warmed up connection: %s\n", host)
+}
+
+// CloseIdleConnections closes connections kept alive for reuse.
+// Connections of in-flight requests are not interrupted.
+func (cr *crawler) CloseIdleConnections() {
+	cr.client.CloseIdleConnections()
+}
+
+// Close releases what the crawler keeps between batches: idle connections,
+// cached responses and rate limits of hosts. A crawler that is used after
+// that starts from scratch.
+func (cr *crawler) Close() error {
+	cr.CloseIdleConnections()
+	cr.PurgeCache()
+	cr.rates.reset()
+	cr.requestRate.reset()
+	cr.debugln("crawler: closed")
+	return nil
+}
+
 // worker reads tasks from the queue and calls crawl to do the job for it.
-func (cr *crawler) worker(ctx context.Context, wg *sync.WaitGroup, tasks chan string, results chan Result) {
+func (cr *crawler) worker(ctx context.Context, wg *sync.WaitGroup, tasks *queue, results chan<- Result) {
 	defer wg.Done()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("crawler: worker stopped:", ctx.Err())
+			cr.debugln("crawler: worker stopped:", ctx.Err())
 			return
-		case url, open := <-tasks:
-			if !open {
-				log.Println("crawler: worker stopped: no more tasks")
-				return
-			}
-			results <- cr.crawl(ctx, url)
+		default:
+		}
+
+		t, ok := tasks.pop()
+		if !ok {
+			cr.debugln("crawler: worker stopped: no more tasks")
+			return
+		}
+		key := cr.cache.cacheKey(t)
+		res, cached := cr.cache.get(key, cr.clock.Now())
+		if !cached {
+			res = cr.crawl(ctx, Request{URL: t.url, Method: t.method, Body: t.body, Header: t.header})
+			cr.cache.put(key, res, cr.clock.Now())
+		}
+		res.index = t.index
+		tasks.done(t)
+		if cr.config.OnResult != nil {
+			cr.config.OnResult(res)
 		}
+		if cr.config.Transform != nil && cr.config.TransformWorkers == 0 {
+			res = cr.transform(res)
+		}
+		results <- res
+	}
+}
+
+// crawl does all the job: send a request, receives a response and passes it
+// back to caller. Failures that may pass are retried up to MaxRetries times,
+// the result of the last attempt is returned.
+func (cr *crawler) crawl(ctx context.Context, r Request) Result {
+	for attempt := 0; ; attempt++ {
+		res := cr.crawlOnce(ctx, r)
+		cr.stats.countResult(res.err)
+		if attempt >= int(cr.config.MaxRetries) || !retryable(res.err) || ctx.Err() != nil {
+			return res
+		}
+		if !cr.backoff(ctx, attempt) {
+			return res
+		}
+		cr.cleanup([]Result{res})
+		cr.infof("crawler: retrying %s: attempt %d: %s\n", r.URL, attempt+1, res.err)
 	}
 }
 
-// crawl does all the job: send a request, receives a response and passes it back to caller.
-func (cr *crawler) crawl(ctx context.Context, url string) (res Result) {
+// crawlOnce sends a single request for the URL.
+func (cr *crawler) crawlOnce(ctx context.Context, r Request) (res Result) {
+	url := r.URL
 	res = Result{SourceURL: url}
 
+	ctx, span := cr.tracer.Start(ctx, "crawler.request")
+	span.SetAttribute("http.url", url)
+	defer func() {
+		if res.err != nil {
+			span.RecordError(res.err)
+		}
+		span.End()
+	}()
+	if cr.config.StartSpan != nil {
+		var finish func(status int, err error)
+		ctx, finish = cr.config.StartSpan(ctx, url)
+		if finish != nil {
+			defer func() {
+				finish(res.StatusCode, res.err)
+			}()
+		}
+	}
+
 	select {
 	case <-ctx.Done():
-		log.Printf("crawler: crawl stopped before starting: %s -> %s\n", url, ctx.Err())
+		cr.debugf("crawler: crawl stopped before starting: %s -> %s\n", url, ctx.Err())
 		res.err = fmt.Errorf("exit on context done: %w", ctx.Err())
 		return
 	default:
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	var reqBody io.Reader
+	if len(r.Body) > 0 {
+		reqBody = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequest(r.Method, url, reqBody)
 	if err != nil {
-		log.Printf("crawler: create get request for %s: %s", url, err.Error())
+		cr.errorf("crawler: create %s request for %s: %s", r.Method, url, err.Error())
 		res.err = fmt.Errorf("create a request: %w", err)
 		return
 	}
 
+	if err = cr.rates.wait(ctx, strings.ToLower(req.URL.Host)); err != nil {
+		cr.debugf("crawler: crawl stopped waiting for host rate limit: %s -> %s\n", url, err)
+		res.err = fmt.Errorf("wait for host rate limit: %w", err)
+		return
+	}
+	if err = cr.requestRate.wait(ctx, ""); err != nil {
+		cr.debugf("crawler: crawl stopped waiting for request rate limit: %s -> %s\n", url, err)
+		res.err = fmt.Errorf("wait for request rate limit: %w", err)
+		return
+	}
+
 	// NOTE: Uncomment to see that code really blocks on N concurrent requests.
 	// time.Sleep(5 * time.Second)
 
+	// Given condition: the timeout covers reading the body as well.
+	ctx, cancel := cr.withTimeout(ctx)
+	defer cancel()
+
 	req = req.WithContext(ctx)
-	log.Println("crawler: sending request:", url)
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", cr.userAgent())
+	setHeaders(req.Header, cr.config.Headers)
+	if userAgent, ok := batchUserAgent(ctx); ok {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if credential, ok := cr.credential(req.URL.Host); ok {
+		credential.apply(req)
+	}
+	if cr.config.ConditionalFrom != nil {
+		etag, lastModified := cr.config.ConditionalFrom(url)
+		setValidators(req.Header, etag, lastModified)
+	}
+	setHeaders(req.Header, r.Header)
+	if traceParent := span.TraceParent(); traceParent != "" {
+		req.Header.Set(traceParentHeader, traceParent)
+	}
+	cr.debugln("crawler: sending request:", url)
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+	if cr.config.LogBodies {
+		defer func() {
+			if res.err != nil {
+				cr.logExchange(LogLevelError, req, resp, body)
+			} else {
+				cr.logExchange(LogLevelDebug, req, resp, body)
+			}
+		}()
+	}
 
-	resp, err := cr.client.Do(req)
+	ex := &exchange{ages: cr.ages}
+	if cr.config.CountConnReuse {
+		ex.stats = &cr.stats
+	}
+	start := cr.clock.Now()
+	defer func() {
+		res.Duration = cr.clock.Now().Sub(start)
+		if threshold := cr.config.SlowRequestThreshold; threshold > 0 && res.Duration > threshold {
+			cr.infof("crawler: slow request: %s: took %s\n", url, res.Duration)
+		}
+	}()
+	resp, err = cr.batchClient(ctx).Do(withClientTrace(req, ex))
+	res.RequestBytes = ex.requestBytes(req)
 	if err != nil {
-		log.Println("crawler: send request:", err)
-		res.err = fmt.Errorf("failed to send a request: %w", err)
+		cr.errorln("crawler: send request:", err)
+		res.err = &sendError{err: err}
 		return
 	}
 	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Println("crawler: close response body:", err)
+		if err := drainAndClose(ctx, resp.Body); err != nil {
+			cr.errorln("crawler: close response body:", err)
 		}
 	}()
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	res.FinalURL = resp.Request.URL.String()
+	res.Headers = cr.redact(resp.Header)
+	res.noStore = noStore(resp)
 
-	// Check response status code.
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("crawler: request failed: %s: status: %d", res.SourceURL, resp.StatusCode)
-		res.err = fmt.Errorf("unexpected response status code: %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusNotModified && conditional(req.Header) {
+		res.StatusCode = resp.StatusCode
+		res.NotModified = true
 		return
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// Check response status code. Rejected responses are kept on the failed
+	// result, so that an upstream that answered with an error can be told
+	// from one that couldn't be reached.
+	if !cr.acceptStatus(resp.StatusCode) {
+		cr.errorf("crawler: request failed: %s: status: %d", res.SourceURL, resp.StatusCode)
+		body = cr.keepRejected(ctx, &res, resp)
+		return
+	}
+
+	body, res.BytesRead, err = readBody(ctx, resp, cr.config.MaxBodySize, !cr.config.RawBody)
+	if err != nil && cr.salvaging() && ctx.Err() == nil && !errors.Is(err, ErrBodyTooLarge) {
+		if salvaged, _, ok := salvageJSON(body); ok {
+			cr.infof("crawler: salvaged truncated body: %s: %s\n", url, err)
+			body, res.Truncated, err = salvaged, true, nil
+		}
+	}
 	if err != nil {
-		log.Println("crawler: read response body:", err)
-		res.err = fmt.Errorf("read a response body: %w", err)
+		cr.errorln("crawler: read response body:", err)
+		if !cr.config.StatusOnReadError {
+			res.err = fmt.Errorf("read a response body: %w", err)
+			return
+		}
+		res.StatusCode = resp.StatusCode
+		res.err = fmt.Errorf("read a response body: status %d: %w", resp.StatusCode, err)
 		return
 	}
 
-	// Check if response body is a valid JSON.
-	var js interface{}
-	if err := json.Unmarshal(body, &js); err != nil {
-		log.Println("crawler: unmarshal response body to JSON:", err)
-		res.err = fmt.Errorf("unmarshal response body to JSON: %w", err)
+	// Pass raw bodies on untouched, they don't have to be JSON at all.
+	if cr.config.RawBody {
+		res.StatusCode = resp.StatusCode
+		res.ContentEncoding = rawContentEncoding(resp)
+		if !cr.config.DiscardBody {
+			if cr.keepBody(&res, bytes.NewBuffer(body)); res.err != nil {
+				return
+			}
+		}
+		cr.debugf("crawler: task finished: raw body: %s [%d]\n", url, resp.StatusCode)
 		return
 	}
 
-	// Remove all special characters from body.
-	buffer := new(bytes.Buffer)
-	if err := json.Compact(buffer, body); err != nil {
-		log.Println("crawler: compact JSON to buffer:", err)
-		res.err = fmt.Errorf("compact JSON to buffer: %w", err)
+	// Convert the body to UTF-8 before validation if the upstream declared another charset.
+	if cr.config.DecodeCharset {
+		if body, err = decodeCharset(resp.Header.Get("Content-Type"), body); err != nil {
+			cr.errorln("crawler: decode response body:", err)
+			res.err = fmt.Errorf("decode response body: %w", err)
+			return
+		}
+	}
+
+	// Check if response body is a valid JSON, unless a custom check replaces it.
+	validator := cr.config.ResponseValidator
+	if validator != nil {
+		if err = validator(body); err != nil {
+			cr.errorln("crawler: validate response body:", err)
+			res.err = fmt.Errorf("validate response body: %w", err)
+			return
+		}
+	} else {
+		var js interface{}
+		if err = json.Unmarshal(body, &js); err != nil && cr.salvaging() {
+			if salvaged, truncated, ok := salvageJSON(body); ok {
+				cr.infof("crawler: salvaged body: %s: %s\n", url, err)
+				body, res.Truncated, err = salvaged, truncated, nil
+			}
+		}
+		if err != nil {
+			cr.errorln("crawler: unmarshal response body to JSON:", err)
+			res.err = fmt.Errorf("unmarshal response body to JSON: %w", err)
+			return
+		}
+		if cr.config.StrictJSON {
+			if err = checkStrictJSON(body); err != nil {
+				cr.errorln("crawler: check response body:", err)
+				res.err = fmt.Errorf("check response body: %w", err)
+				return
+			}
+		}
+	}
+	isJSON := validator == nil || json.Valid(body)
+
+	// Some APIs report errors in a body sent with 200.
+	if isJSON && cr.config.MaxRetries > 0 && cr.config.RetryIfJSON != nil && cr.config.RetryIfJSON(body) {
+		cr.errorf("crawler: request failed: %s: %s\n", res.SourceURL, errRetryBody)
+		res.err = errRetryBody
 		return
 	}
 
-	log.Printf("crawler: task finished: %s [%d]\n", url, resp.StatusCode)
+	res.StatusCode = resp.StatusCode
+	if cr.config.DiscardBody {
+		cr.debugf("crawler: task finished: body discarded: %s [%d]\n", url, resp.StatusCode)
+		return
+	}
+
+	// Remove all special characters from body. Bodies that passed a custom
+	// check are kept verbatim, those that aren't JSON are flagged as such.
+	var buffer *bytes.Buffer
+	switch {
+	case validator != nil:
+		buffer = bytes.NewBuffer(body)
+		if !isJSON {
+			res.ContentEncoding = "identity"
+		}
+	case cr.config.CanonicalJSON:
+		if buffer, err = canonicalJSON(body); err != nil {
+			cr.errorln("crawler: canonicalize JSON:", err)
+			res.err = fmt.Errorf("canonicalize JSON: %w", err)
+			return
+		}
+	default:
+		buffer = new(bytes.Buffer)
+		if err := json.Compact(buffer, body); err != nil {
+			cr.errorln("crawler: compact JSON to buffer:", err)
+			res.err = fmt.Errorf("compact JSON to buffer: %w", err)
+			return
+		}
+	}
+
+	cr.keepBody(&res, buffer)
+	if res.err != nil {
+		return
+	}
+
+	cr.debugf("crawler: task finished: %s [%d]\n", url, resp.StatusCode)
+	return
+}
+
+// salvaging reports whether truncated JSON bodies are salvaged.
+func (cr *crawler) salvaging() bool {
+	return cr.config.SalvageTruncated && !cr.config.RawBody && cr.config.ResponseValidator == nil
+}
+
+// acceptStatus reports whether the response status counts as a success.
+func (cr *crawler) acceptStatus(code int) bool {
+	if cr.config.AcceptStatus != nil {
+		return cr.config.AcceptStatus(code)
+	}
+	return code == http.StatusOK
+}
+
+// keepRejected fails the result with the status of the response and keeps
+// the body, if it can be read, as it is. Bodies that aren't JSON are flagged
+// with their ContentEncoding like raw ones. It returns the body for logging.
+func (cr *crawler) keepRejected(ctx context.Context, res *Result, resp *http.Response) []byte {
+	res.StatusCode = resp.StatusCode
+	body, n, err := readBody(ctx, resp, cr.config.MaxBodySize, !cr.config.RawBody)
+	res.BytesRead = n
+	if err != nil {
+		cr.errorln("crawler: read rejected response body:", err)
+	} else if !cr.config.DiscardBody {
+		switch {
+		case cr.config.RawBody:
+			res.ContentEncoding = rawContentEncoding(resp)
+		case !json.Valid(body):
+			res.ContentEncoding = "identity"
+		}
+		cr.keepBody(res, bytes.NewBuffer(body))
+	}
+	// The status is what failed the URL, even if the body couldn't be kept.
+	res.err = &statusError{code: resp.StatusCode}
+	return body
+}
+
+// AcceptAnyBody is a ResponseValidator that lets bodies of any format pass.
+func AcceptAnyBody([]byte) error {
+	return nil
+}
+
+// rawContentEncoding returns the encoding of a body passed on as it was
+// received, "identity" if the response didn't declare one.
+func rawContentEncoding(resp *http.Response) string {
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		return encoding
+	}
+	return "identity"
+}
+
+// keepBody stores the body on the result, failures are set as its error.
+func (cr *crawler) keepBody(res *Result, body *bytes.Buffer) {
+	// Keep large bodies off the heap until the response is assembled.
+	if cr.config.SpillDir == "" {
+		res.ResponseBody = json.RawMessage(body.String())
+		return
+	}
 
-	return Result{
-		SourceURL:    url,
-		StatusCode:   resp.StatusCode,
-		ResponseBody: json.RawMessage(buffer.String()),
+	var err error
+	if res.BodyPath, err = spill(cr.config.SpillDir, body); err != nil {
+		cr.errorln("crawler: spill response body:", err)
+		res.err = fmt.Errorf("spill response body to disk: %w", err)
 	}
 }
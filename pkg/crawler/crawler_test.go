@@ -0,0 +1,270 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+// newTestCrawler returns a crawler of the config that logs nothing.
+func newTestCrawler(t *testing.T, cfg Config) *crawler {
+	t.Helper()
+	cfg.LogLevel = LogLevelSilent
+	c, err := NewWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewWithConfig() error = %v", err)
+	}
+	cr := c.(*crawler)
+	t.Cleanup(cr.CloseIdleConnections)
+	return cr
+}
+
+// newJSONServer returns a server that answers every request with the body.
+func newJSONServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTruncatedServer returns a server that declares a Content-Length of
+// length, sends the body and drops the connection.
+func newTruncatedServer(t *testing.T, body string, length int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", length, body)
+		_ = buf.Flush()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// gzipBytes returns the body compressed with gzip.
+func gzipBytes(t *testing.T, body string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write([]byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newGzipServer returns a server that compresses the body with gzip for
+// requests that accept it.
+func newGzipServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	compressed := gzipBytes(t, body)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(compressed)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDiscardBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		discard  bool
+		body     string
+		wantBody string
+		wantErr  bool
+	}{
+		{name: "stored", body: `{ "a": 1 }`, wantBody: `{"a":1}`},
+		{name: "discarded", discard: true, body: `{ "a": 1 }`},
+		{name: "discarded body still validated", discard: true, body: `{"a":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newJSONServer(t, tt.body)
+			cr := newTestCrawler(t, Config{DiscardBody: tt.discard})
+
+			results, err := cr.Crawl(context.Background(), []string{srv.URL})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Crawl() error = nil, want invalid JSON")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Crawl() error = %v", err)
+			}
+			if got := results[0].StatusCode; got != http.StatusOK {
+				t.Errorf("StatusCode = %d, want %d", got, http.StatusOK)
+			}
+			if got := string(results[0].ResponseBody); got != tt.wantBody {
+				t.Errorf("ResponseBody = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestNewClientTransport(t *testing.T) {
+	tests := []struct {
+		name                string
+		cfg                 Config
+		disableKeepAlives   bool
+		maxIdleConns        int
+		maxIdleConnsPerHost int
+		maxConnsPerHost     int
+		idleConnTimeout     time.Duration
+	}{
+		{
+			name:                "derived from MaxConnections",
+			cfg:                 Config{MaxConnections: 8},
+			maxIdleConns:        8,
+			maxIdleConnsPerHost: 8,
+			maxConnsPerHost:     8,
+			idleConnTimeout:     http.DefaultTransport.(*http.Transport).IdleConnTimeout,
+		},
+		{
+			name: "set individually",
+			cfg: Config{
+				MaxConnections:      8,
+				DisableKeepAlives:   true,
+				MaxIdleConns:        100,
+				MaxIdleConnsPerHost: 50,
+				MaxConnsPerHost:     2,
+				IdleConnTimeout:     time.Second,
+			},
+			disableKeepAlives:   true,
+			maxIdleConns:        100,
+			maxIdleConnsPerHost: 50,
+			maxConnsPerHost:     2,
+			idleConnTimeout:     time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := newClient(tt.cfg, clock.New())
+			tr := client.Transport.(*http.Transport)
+			if tr.DisableKeepAlives != tt.disableKeepAlives {
+				t.Errorf("DisableKeepAlives = %t, want %t", tr.DisableKeepAlives, tt.disableKeepAlives)
+			}
+			if tr.MaxIdleConns != tt.maxIdleConns {
+				t.Errorf("MaxIdleConns = %d, want %d", tr.MaxIdleConns, tt.maxIdleConns)
+			}
+			if tr.MaxIdleConnsPerHost != tt.maxIdleConnsPerHost {
+				t.Errorf("MaxIdleConnsPerHost = %d, want %d", tr.MaxIdleConnsPerHost, tt.maxIdleConnsPerHost)
+			}
+			if tr.MaxConnsPerHost != tt.maxConnsPerHost {
+				t.Errorf("MaxConnsPerHost = %d, want %d", tr.MaxConnsPerHost, tt.maxConnsPerHost)
+			}
+			if tr.IdleConnTimeout != tt.idleConnTimeout {
+				t.Errorf("IdleConnTimeout = %s, want %s", tr.IdleConnTimeout, tt.idleConnTimeout)
+			}
+		})
+	}
+}
+
+func TestStatusOnReadError(t *testing.T) {
+	srv := newTruncatedServer(t, `[1,2,`, 100)
+
+	for _, keep := range []bool{false, true} {
+		cr := newTestCrawler(t, Config{ContinueOnError: true, StatusOnReadError: keep})
+		results, _ := cr.Crawl(context.Background(), []string{srv.URL})
+		if len(results) != 1 {
+			t.Fatalf("keep %t: got %d results, want 1", keep, len(results))
+		}
+		res := results[0]
+		if !errors.Is(res.Err(), ErrTruncatedBody) {
+			t.Errorf("keep %t: error = %v, want %v", keep, res.Err(), ErrTruncatedBody)
+		}
+		want := 0
+		if keep {
+			want = http.StatusOK
+		}
+		if res.StatusCode != want {
+			t.Errorf("keep %t: StatusCode = %d, want %d", keep, res.StatusCode, want)
+		}
+	}
+}
+
+func TestRawBodyGzip(t *testing.T) {
+	const body = `{ "a": [1, 2, 3] }`
+	srv := newGzipServer(t, body)
+
+	raw := newTestCrawler(t, Config{RawBody: true, Headers: http.Header{"Accept-Encoding": {"gzip"}}})
+	results, err := raw.Crawl(context.Background(), []string{srv.URL})
+	if err != nil {
+		t.Fatalf("raw: Crawl() error = %v", err)
+	}
+	if got := results[0].ContentEncoding; got != "gzip" {
+		t.Errorf("raw: ContentEncoding = %q, want %q", got, "gzip")
+	}
+	if got, want := []byte(results[0].ResponseBody), gzipBytes(t, body); !bytes.Equal(got, want) {
+		t.Errorf("raw: ResponseBody = %x, want the compressed bytes %x", got, want)
+	}
+
+	decoded := newTestCrawler(t, Config{})
+	if results, err = decoded.Crawl(context.Background(), []string{srv.URL}); err != nil {
+		t.Fatalf("decoded: Crawl() error = %v", err)
+	}
+	if got, want := string(results[0].ResponseBody), `{"a":[1,2,3]}`; got != want {
+		t.Errorf("decoded: ResponseBody = %s, want %s", got, want)
+	}
+	if got := results[0].ContentEncoding; got != "" {
+		t.Errorf("decoded: ContentEncoding = %q, want none", got)
+	}
+}
+
+func TestRawBodyNDJSON(t *testing.T) {
+	const body = "{\"a\": 1}\n{ \"b\": 2 }\n"
+	srv := newGzipServer(t, body)
+
+	cr := newTestCrawler(t, Config{RawBody: true})
+	results, err := cr.Crawl(context.Background(), []string{srv.URL})
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if got := results[0].ContentEncoding; got != "identity" {
+		t.Errorf("ContentEncoding = %q, want %q", got, "identity")
+	}
+	if got := string(results[0].ResponseBody); got != body {
+		t.Errorf("ResponseBody = %q, want it verbatim %q", got, body)
+	}
+}
+
+func TestRawBodyKeepsAcceptEncoding(t *testing.T) {
+	const body = `{"a":1}`
+	srv := newGzipServer(t, body)
+
+	cr := newTestCrawler(t, Config{RawBody: true, Headers: http.Header{"Accept-Encoding": {"identity"}}})
+	results, err := cr.Crawl(context.Background(), []string{srv.URL})
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if got := results[0].ContentEncoding; got != "identity" {
+		t.Errorf("ContentEncoding = %q, want %q", got, "identity")
+	}
+	if got := string(results[0].ResponseBody); got != body {
+		t.Errorf("ResponseBody = %q, want %q", got, body)
+	}
+}
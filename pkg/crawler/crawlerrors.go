@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// InvalidURLError is the error ValidateURLs reports for a URL of the batch.
+type InvalidURLError struct {
+	Index int // Position of the URL in the batch.
+	URL   string
+	Err   error
+}
+
+// Error returns the reason prefixed with the position of the URL.
+func (e *InvalidURLError) Error() string {
+	return fmt.Sprintf("url %d: %s", e.Index, e.Err)
+}
+
+// Unwrap returns the reason the URL failed validation.
+func (e *InvalidURLError) Unwrap() error {
+	return e.Err
+}
+
+// CrawlError is the error of a URL of the batch that failed to be crawled.
+type CrawlError struct {
+	Index int // Position of the URL in the batch.
+	URL   string
+	Err   error
+}
+
+// Error returns the reason prefixed with the position of the URL and the URL.
+func (e *CrawlError) Error() string {
+	return fmt.Sprintf("url %d: %q: %s", e.Index, e.URL, e.Err)
+}
+
+// Unwrap returns the reason the URL failed.
+func (e *CrawlError) Unwrap() error {
+	return e.Err
+}
+
+// CrawlErrors is returned along with the results of a batch crawled with
+// ContinueOnError if any of its URLs failed: one error per failed URL in
+// the order of the batch, duplicates included, with the same reasons their
+// results have on Err.
+type CrawlErrors []*CrawlError
+
+// Interface compliance check.
+var _ error = CrawlErrors(nil)
+
+// Error lists the failed URLs in the order of the batch.
+func (e CrawlErrors) Error() string {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "failed to crawl %d URLs", len(e))
+	for _, err := range e {
+		fmt.Fprintf(&msg, "; %s", err)
+	}
+	return msg.String()
+}
+
+// Unwrap returns the errors of the failed URLs in the order of the batch.
+func (e CrawlErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Is reports whether any of the errors matches the target. It makes errors.Is
+// look into the errors on Go versions that don't know Unwrap() []error.
+func (e CrawlErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors, in the order of the batch, that matches
+// the target, as errors.As does. Like Is, it covers Go versions before
+// Unwrap() []error.
+func (e CrawlErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCrawlErrorsKeepDuplicateURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	bad := srv.URL + "/bad"
+	cr := newTestCrawler(t, Config{ContinueOnError: true})
+	results, err := cr.Crawl(context.Background(), []string{bad, srv.URL + "/ok", bad, bad})
+	if len(results) != 4 {
+		t.Fatalf("Crawl() returned %d results, want 4", len(results))
+	}
+
+	var failed CrawlErrors
+	if !errors.As(err, &failed) {
+		t.Fatalf("Crawl() error = %v, want CrawlErrors", err)
+	}
+	if len(failed) != 3 {
+		t.Fatalf("CrawlErrors has %d errors, want one per failed URL: %v", len(failed), failed)
+	}
+	for i, want := range []int{0, 2, 3} {
+		if failed[i].Index != want || failed[i].URL != bad {
+			t.Errorf("CrawlErrors[%d] is of URL %d %s, want %d %s", i, failed[i].Index, failed[i].URL, want, bad)
+		}
+		if failed[i].Err != results[want].Err() {
+			t.Errorf("CrawlErrors[%d].Err = %v, want the error of its result %v", i, failed[i].Err, results[want].Err())
+		}
+	}
+
+	var crawlErr *CrawlError
+	if !errors.As(err, &crawlErr) || crawlErr.Index != 0 {
+		t.Errorf("errors.As(*CrawlError) = %v, want the first failed URL", crawlErr)
+	}
+}
@@ -0,0 +1,62 @@
+package crawler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Credential authenticates the requests to a host: with a bearer Token if
+// it is set, with basic auth of Username and Password otherwise.
+type Credential struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// Redacted returns a copy of the credential with the password and the token
+// replaced by RedactedValue.
+func (c Credential) Redacted() Credential {
+	if c.Password != "" {
+		c.Password = RedactedValue
+	}
+	if c.Token != "" {
+		c.Token = RedactedValue
+	}
+	return c
+}
+
+// apply sets the Authorization header of the request.
+func (c Credential) apply(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+		return
+	}
+	req.SetBasicAuth(c.Username, c.Password)
+}
+
+// normalizeCredentials returns a copy of the credentials keyed by lowercased
+// host, so that the caller can't change them afterwards.
+func normalizeCredentials(credentials map[string]Credential) map[string]Credential {
+	if len(credentials) == 0 {
+		return nil
+	}
+	normalized := make(map[string]Credential, len(credentials))
+	for host, c := range credentials {
+		normalized[strings.ToLower(host)] = c
+	}
+	return normalized
+}
+
+// credential returns the credential of the host, as in the URL with the port
+// if any. Entries without a port match the host at any port.
+func (cr *crawler) credential(host string) (Credential, bool) {
+	host = strings.ToLower(host)
+	if c, ok := cr.credentials[host]; ok {
+		return c, true
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		c, ok := cr.credentials[host[:i]]
+		return c, ok
+	}
+	return Credential{}, false
+}
@@ -0,0 +1,20 @@
+package crawler
+
+import "net/http"
+
+// logExchange logs the request and the response of a crawl for debugging:
+// bodies are cut to LogBodyMaxBytes and sensitive headers are redacted.
+func (cr *crawler) logExchange(level LogLevel, req *http.Request, resp *http.Response, body []byte) {
+	if !cr.enabled(level) {
+		return
+	}
+	cr.logf(level, "crawler: debug: request: %s %s: headers: %v\n", req.Method, req.URL.Redacted(), cr.redact(req.Header))
+	if resp == nil {
+		return
+	}
+	if max := cr.config.LogBodyMaxBytes; max > 0 && int64(len(body)) > max {
+		body = body[:max]
+	}
+	cr.logf(level, "crawler: debug: response: %s: status: %d: headers: %v: body: %q\n",
+		req.URL.Redacted(), resp.StatusCode, cr.redact(resp.Header), body)
+}
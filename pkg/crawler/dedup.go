@@ -0,0 +1,56 @@
+package crawler
+
+import "strings"
+
+// deduplicate leaves the first of identical tasks in the batch: same URL,
+// method, body and headers. It returns the remaining tasks along with the
+// indexes of the dropped duplicates by the index of the task they share.
+func deduplicate(tasks []task) ([]task, map[int][]int) {
+	first := make(map[string]int, len(tasks))
+	duplicates := make(map[int][]int)
+
+	unique := tasks[:0]
+	for _, t := range tasks {
+		key := t.dedupKey()
+		if i, ok := first[key]; ok {
+			duplicates[i] = append(duplicates[i], t.index)
+			continue
+		}
+		first[key] = t.index
+		unique = append(unique, t)
+	}
+	return unique, duplicates
+}
+
+// dedupKey returns the key that identical tasks share. Header.Write sorts
+// the keys, so headers set in a different order give the same key.
+func (t task) dedupKey() string {
+	var key strings.Builder
+	key.WriteString(t.method)
+	key.WriteByte(' ')
+	key.WriteString(t.url)
+	key.WriteByte(0)
+	key.Write(t.body)
+	key.WriteByte(0)
+	_ = t.header.Write(&key)
+	return key.String()
+}
+
+// fanOutStage passes on every result along with a copy of it for each of
+// its duplicates. Copies share the body, spilled ones included. The returned
+// channel is closed once in is closed and drained.
+func fanOutStage(in <-chan Result, duplicates map[int][]int) <-chan Result {
+	out := make(chan Result)
+	go func() {
+		defer close(out)
+		for res := range in {
+			indexes := duplicates[res.index]
+			out <- res
+			for _, i := range indexes {
+				res.index = i
+				out <- res
+			}
+		}
+	}()
+	return out
+}
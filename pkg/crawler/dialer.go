@@ -0,0 +1,175 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// fallbackDelay is how long the dialer waits for an address of the first
+// family before it races one of the other, as net.Dialer does by default.
+const fallbackDelay = 300 * time.Millisecond
+
+// dialer throttles DNS resolution independently of the number of connections:
+// at most cap(lookups) hosts are resolved at the same time.
+type dialer struct {
+	net.Dialer
+	lookups chan struct{}
+	resolve func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// newDialer returns a dialer with the same timeouts as http.DefaultTransport.
+func newDialer(maxLookups int) *dialer {
+	return &dialer{
+		Dialer:  newNetDialer(),
+		lookups: make(chan struct{}, maxLookups),
+		resolve: net.DefaultResolver.LookupIPAddr,
+	}
+}
+
+// DialContext resolves the host and connects to its addresses the way
+// net.Dialer does: one after another within a family and, for dual-stack
+// hosts, the other family too once the first one fails or takes longer
+// than fallbackDelay, so that a black-holed IPv6 route doesn't hold up IPv4.
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	primaries, fallbacks := partitionAddrs(network, addrs)
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("no suitable address found for %s", host)
+	}
+	if len(fallbacks) == 0 {
+		return d.dialSerial(ctx, network, port, primaries)
+	}
+	return d.dialParallel(ctx, network, port, primaries, fallbacks)
+}
+
+// dialParallel races the fallbacks against the primaries once the primaries
+// fail or fallbackDelay passes, and returns the first connection made.
+func (d *dialer) dialParallel(ctx context.Context, network, port string, primaries, fallbacks []net.IPAddr) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	start := func(addrs []net.IPAddr) {
+		go func() {
+			conn, err := d.dialSerial(ctx, network, port, addrs)
+			results <- dialResult{conn: conn, err: err}
+		}()
+	}
+
+	start(primaries)
+	pending, fallbackStarted := 1, false
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				start(fallbacks)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// The loser may still connect before it sees the cancel.
+				if pending > 0 {
+					go func() {
+						if res := <-results; res.conn != nil {
+							_ = res.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				start(fallbacks)
+				continue
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial connects to the addresses one by one until one of them accepts.
+func (d *dialer) dialSerial(ctx context.Context, network, port string, addrs []net.IPAddr) (net.Conn, error) {
+	var dialErr error
+	for _, addr := range addrs {
+		conn, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		dialErr = err
+	}
+	return nil, dialErr
+}
+
+// partitionAddrs leaves the addresses that suit the network and splits them
+// into those of the family of the first one and the others.
+func partitionAddrs(network string, addrs []net.IPAddr) (primaries, fallbacks []net.IPAddr) {
+	var primaryIsV4 bool
+	for _, addr := range addrs {
+		isV4 := addr.IP.To4() != nil
+		if (network == "tcp4" && !isV4) || (network == "tcp6" && isV4) {
+			continue
+		}
+		if len(primaries) == 0 {
+			primaryIsV4 = isV4
+		}
+		if isV4 == primaryIsV4 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// lookup resolves the host once a lookup slot is free.
+func (d *dialer) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case d.lookups <- struct{}{}:
+	}
+	defer func() { <-d.lookups }()
+
+	addrs, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses found for " + host)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDialerLimitsConcurrentLookups(t *testing.T) {
+	const (
+		hosts      = 50
+		maxLookups = 3
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	var inFlight, peak int32
+	d := newDialer(maxLookups)
+	d.resolve = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, hosts)
+	for i := 0; i < hosts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort(fmt.Sprintf("host%d.test", i), port))
+			if err != nil {
+				errs <- err
+				return
+			}
+			_ = conn.Close()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("DialContext() error = %v", err)
+	}
+	if peak > maxLookups {
+		t.Errorf("peak concurrent lookups = %d, want at most %d", peak, maxLookups)
+	}
+}
+
+func TestDialerFallsBackToOtherFamily(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	d := newDialer(1)
+	d.resolve = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	// Stand in for a black-holed IPv6 route.
+	d.Control = func(network, address string, _ syscall.RawConn) error {
+		if network == "tcp6" {
+			time.Sleep(2 * time.Second)
+			return fmt.Errorf("black hole: %s", address)
+		}
+		return nil
+	}
+
+	start := time.Now()
+	conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("dual.test", port))
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer conn.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("DialContext() took %s, want the IPv4 fallback after %s", elapsed, fallbackDelay)
+	}
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Errorf("connected to %s, want 127.0.0.1", host)
+	}
+}
+
+func TestPartitionAddrs(t *testing.T) {
+	v4a, v4b, v6 := net.IPAddr{IP: net.ParseIP("192.0.2.1")}, net.IPAddr{IP: net.ParseIP("192.0.2.2")}, net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+	tests := []struct {
+		network       string
+		addrs         []net.IPAddr
+		wantPrimaries int
+		wantFallbacks int
+	}{
+		{network: "tcp", addrs: []net.IPAddr{v6, v4a, v4b}, wantPrimaries: 1, wantFallbacks: 2},
+		{network: "tcp", addrs: []net.IPAddr{v4a, v6, v4b}, wantPrimaries: 2, wantFallbacks: 1},
+		{network: "tcp4", addrs: []net.IPAddr{v6, v4a}, wantPrimaries: 1},
+		{network: "tcp6", addrs: []net.IPAddr{v4a, v4b}},
+	}
+	for _, tt := range tests {
+		primaries, fallbacks := partitionAddrs(tt.network, tt.addrs)
+		if len(primaries) != tt.wantPrimaries || len(fallbacks) != tt.wantFallbacks {
+			t.Errorf("partitionAddrs(%s, %v) = %v, %v, want %d primaries and %d fallbacks",
+				tt.network, tt.addrs, primaries, fallbacks, tt.wantPrimaries, tt.wantFallbacks)
+		}
+	}
+}
+
+func TestDefaultConfigLimitsLookups(t *testing.T) {
+	if got := DefaultConfig().MaxConcurrentDNS; got <= 0 {
+		t.Errorf("DefaultConfig().MaxConcurrentDNS = %d, want a limit", got)
+	}
+}
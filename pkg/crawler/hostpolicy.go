@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hostPolicyKey is the context key of the policy a batch started with.
+type hostPolicyKey struct{}
+
+// HostPolicy decides which hosts may be crawled. Entries are host names
+// without a port, "*.example.com" also matches all subdomains of
+// example.com. Deny wins over Allow, an empty Allow allows all hosts.
+type HostPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// UpdateHostPolicy replaces the host policy. Batches in flight keep the
+// policy they started with, the next ones get the new one.
+func (cr *crawler) UpdateHostPolicy(policy HostPolicy) {
+	cr.policy.Store(policy.normalized())
+}
+
+// hostPolicy returns the current host policy.
+func (cr *crawler) hostPolicy() HostPolicy {
+	return cr.policy.Load().(HostPolicy)
+}
+
+// withHostPolicy returns ctx carrying the policy for checkRedirect.
+func withHostPolicy(ctx context.Context, policy HostPolicy) context.Context {
+	return context.WithValue(ctx, hostPolicyKey{}, policy)
+}
+
+// checkRedirect returns the CheckRedirect function of the client. It follows
+// up to maxRedirects redirects, none if it is zero, and refuses redirects to
+// hosts that the policy of the batch doesn't allow, so that they can't be used
+// to get around it.
+func checkRedirect(maxRedirects int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if maxRedirects <= 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > maxRedirects {
+			return fmt.Errorf("%w: stopped after %d", errTooManyRedirects, maxRedirects)
+		}
+		if policy, ok := req.Context().Value(hostPolicyKey{}).(HostPolicy); ok && !policy.allows(req.URL.Host) {
+			return fmt.Errorf("redirect: %w: %q", ErrHostNotAllowed, req.URL.String())
+		}
+		return nil
+	}
+}
+
+// allows reports whether the host, with or without a port, may be crawled.
+func (p HostPolicy) allows(host string) bool {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	host = strings.Trim(strings.ToLower(host), "[]")
+
+	for _, pattern := range p.Deny {
+		if matchHost(pattern, host) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalized returns a copy of the policy with lowercased entries, so that
+// the caller can't change it afterwards.
+func (p HostPolicy) normalized() HostPolicy {
+	lower := func(hosts []string) []string {
+		if hosts == nil {
+			return nil
+		}
+		lowered := make([]string, len(hosts))
+		for i, host := range hosts {
+			lowered[i] = strings.ToLower(host)
+		}
+		return lowered
+	}
+	return HostPolicy{Allow: lower(p.Allow), Deny: lower(p.Deny)}
+}
+
+// matchHost reports whether the host matches the pattern of a HostPolicy.
+func matchHost(pattern, host string) bool {
+	if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+		return strings.HasSuffix(host, suffix) || host == suffix[1:]
+	}
+	return host == pattern
+}
@@ -0,0 +1,99 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpdateHostPolicyMidBatch(t *testing.T) {
+	var cr *crawler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/first" {
+			// The policy changes while the batch is in flight, the redirect
+			// and the rest of the batch are still checked against the old one.
+			cr.UpdateHostPolicy(HostPolicy{Deny: []string{"127.0.0.1"}})
+			http.Redirect(w, r, "/redirected", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cr = newTestCrawler(t, Config{MaxConnections: 1, MaxRedirects: 1})
+	results, err := cr.Crawl(context.Background(), []string{srv.URL + "/first", srv.URL + "/second"})
+	if err != nil {
+		t.Fatalf("in-flight batch: Crawl() error = %v", err)
+	}
+	if got := results[0].FinalURL; got != srv.URL+"/redirected" {
+		t.Errorf("in-flight batch: FinalURL = %q, want the redirect followed", got)
+	}
+
+	if _, err = cr.Crawl(context.Background(), []string{srv.URL + "/second"}); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("next batch: Crawl() error = %v, want %v", err, ErrHostNotAllowed)
+	}
+
+	cr.UpdateHostPolicy(HostPolicy{Allow: []string{"*.example.com", "127.0.0.1"}})
+	if _, err = cr.Crawl(context.Background(), []string{srv.URL + "/second"}); err != nil {
+		t.Errorf("after allowing the host again: Crawl() error = %v", err)
+	}
+}
+
+func TestHostPolicyAllows(t *testing.T) {
+	policy := HostPolicy{Allow: []string{"*.Example.com", "api.test"}, Deny: []string{"private.example.com"}}.normalized()
+	tests := map[string]bool{
+		"example.com":          true,
+		"www.example.com:8080": true,
+		"private.example.com":  false,
+		"API.test":             true,
+		"other.test":           false,
+		"notexample.com":       false,
+		"[::1]:80":             false,
+	}
+	for host, want := range tests {
+		if got := policy.allows(host); got != want {
+			t.Errorf("allows(%q) = %t, want %t", host, got, want)
+		}
+	}
+	if !(HostPolicy{}).allows("any.test") {
+		t.Error("empty policy doesn't allow any.test")
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Location", r.URL.Path)
+		w.WriteHeader(http.StatusFound)
+		_, _ = w.Write([]byte(`{"redirect":true}`))
+	}))
+	defer srv.Close()
+
+	cr := newTestCrawler(t, Config{MaxRedirects: 3})
+	if _, err := cr.Crawl(context.Background(), []string{srv.URL + "/loop"}); !errors.Is(err, errTooManyRedirects) {
+		t.Errorf("redirect loop: Crawl() error = %v, want %v", err, errTooManyRedirects)
+	}
+	if got := atomic.LoadInt32(&hits); got != 4 {
+		t.Errorf("redirect loop: %d requests, want the first one and 3 redirects", got)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	cr = newTestCrawler(t, Config{AcceptStatus: func(code int) bool { return code == http.StatusFound }})
+	results, err := cr.Crawl(context.Background(), []string{srv.URL + "/loop"})
+	if err != nil {
+		t.Fatalf("no redirects: Crawl() error = %v", err)
+	}
+	if got := results[0].StatusCode; got != http.StatusFound {
+		t.Errorf("no redirects: StatusCode = %d, want %d", got, http.StatusFound)
+	}
+	if got := results[0].FinalURL; got != srv.URL+"/loop" {
+		t.Errorf("no redirects: FinalURL = %q, want %q", got, srv.URL+"/loop")
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("no redirects: %d requests, want 1", got)
+	}
+}
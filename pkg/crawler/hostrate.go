@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+// maxIdleHostRates is the number of hosts after which buckets of idle hosts
+// are dropped: a full bucket is no different from a new one.
+const maxIdleHostRates = 1024
+
+type (
+	// hostRates keeps a token bucket per host, shared by all batches.
+	hostRates struct {
+		sync.Mutex
+		clock     clock.Clock
+		qps       float64
+		overrides map[string]float64
+		burst     float64
+		buckets   map[string]*bucket
+	}
+	bucket struct {
+		rate   float64 // Tokens added per second.
+		tokens float64 // Goes negative when waiting requests reserved tokens ahead.
+		last   time.Time
+	}
+)
+
+// newHostRates returns the registry for the config, nil if no host is limited.
+func newHostRates(cfg Config, c clock.Clock) *hostRates {
+	if cfg.HostQPS <= 0 && len(cfg.HostQPSOverrides) == 0 {
+		return nil
+	}
+	burst := float64(cfg.HostBurst)
+	if burst < 1 {
+		burst = 1
+	}
+	overrides := make(map[string]float64, len(cfg.HostQPSOverrides))
+	for host, qps := range cfg.HostQPSOverrides {
+		overrides[strings.ToLower(host)] = qps
+	}
+	return &hostRates{
+		clock:     c,
+		qps:       cfg.HostQPS,
+		overrides: overrides,
+		burst:     burst,
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// reset drops the buckets of all hosts.
+func (hr *hostRates) reset() {
+	if hr == nil {
+		return
+	}
+
+	hr.Lock()
+	defer hr.Unlock()
+
+	hr.buckets = make(map[string]*bucket)
+}
+
+// newRequestRate returns the limit of RequestsPerSecond, nil if there is
+// none: a registry where all requests share the bucket of the empty host,
+// which spaces them out evenly.
+func newRequestRate(cfg Config, c clock.Clock) *hostRates {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	return &hostRates{
+		clock:   c,
+		qps:     cfg.RequestsPerSecond,
+		burst:   1,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// wait blocks until the host may get another request or ctx is done.
+func (hr *hostRates) wait(ctx context.Context, host string) error {
+	if hr == nil {
+		return nil
+	}
+	delay, ok := hr.reserve(host)
+	if !ok || delay <= 0 {
+		return nil
+	}
+
+	timer := hr.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		hr.cancel(host)
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// reserve takes a token of the host and returns how long to wait until it
+// is due. It reports false if the host isn't limited.
+func (hr *hostRates) reserve(host string) (time.Duration, bool) {
+	hr.Lock()
+	defer hr.Unlock()
+
+	now := hr.clock.Now()
+	b, ok := hr.buckets[host]
+	if !ok {
+		rate, limited := hr.overrides[host]
+		if !limited {
+			rate = hr.qps
+		}
+		if rate <= 0 {
+			return 0, false
+		}
+		if len(hr.buckets) >= maxIdleHostRates {
+			hr.evictIdle(now)
+		}
+		b = &bucket{rate: rate, tokens: hr.burst, last: now}
+		hr.buckets[host] = b
+	}
+
+	b.refill(now, hr.burst)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+// cancel gives back the token of a request that stopped waiting.
+func (hr *hostRates) cancel(host string) {
+	hr.Lock()
+	defer hr.Unlock()
+
+	if b, ok := hr.buckets[host]; ok {
+		b.tokens++
+	}
+}
+
+// evictIdle drops buckets that have refilled completely.
+func (hr *hostRates) evictIdle(now time.Time) {
+	for host, b := range hr.buckets {
+		if b.refill(now, hr.burst); b.tokens >= hr.burst {
+			delete(hr.buckets, host)
+		}
+	}
+}
+
+// refill adds the tokens earned since the last refill, up to burst.
+func (b *bucket) refill(now time.Time, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
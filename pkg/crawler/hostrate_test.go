@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+)
+
+func TestHostRatesTwoHosts(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	hr := newHostRates(Config{
+		HostQPS:          10,
+		HostQPSOverrides: map[string]float64{"Slow.test": 2, "free.test": 0},
+	}, fake)
+
+	// Every request reserves the next token, so the delays add up.
+	for i, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got, _ := hr.reserve("fast.test"); got != want {
+			t.Errorf("fast.test request %d: delay = %s, want %s", i, got, want)
+		}
+	}
+	for i, want := range []time.Duration{0, 500 * time.Millisecond, time.Second} {
+		if got, _ := hr.reserve("slow.test"); got != want {
+			t.Errorf("slow.test request %d: delay = %s, want %s", i, got, want)
+		}
+	}
+	if _, limited := hr.reserve("free.test"); limited {
+		t.Error("free.test is limited, want it exempt")
+	}
+
+	// Tokens earned later are available right away.
+	fake.Advance(2 * time.Second)
+	for _, host := range []string{"fast.test", "slow.test"} {
+		if got, _ := hr.reserve(host); got != 0 {
+			t.Errorf("%s after a pause: delay = %s, want none", host, got)
+		}
+	}
+}
+
+func TestHostRatesWait(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	hr := newHostRates(Config{HostQPS: 1}, fake)
+
+	if err := hr.wait(context.Background(), "a.test"); err != nil {
+		t.Fatalf("first wait: error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- hr.wait(context.Background(), "a.test") }()
+	for fake.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("second wait returned %v before its token was due", err)
+	default:
+	}
+	fake.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("second wait: error = %v", err)
+	}
+
+	// A canceled wait gives its token back to the next request.
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- hr.wait(ctx, "a.test") }()
+	for fake.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("canceled wait: error = %v, want %v", err, context.Canceled)
+	}
+	if got, _ := hr.reserve("a.test"); got != time.Second {
+		t.Errorf("after a canceled wait: delay = %s, want %s", got, time.Second)
+	}
+}
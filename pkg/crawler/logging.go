@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Logger receives the messages of the crawler, *log.Logger is one.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// LogLevel sets how verbose the crawler is. The zero value logs everything.
+type LogLevel uint8
+
+const (
+	LogLevelDebug  LogLevel = iota // Everything, including per-request and per-worker chatter.
+	LogLevelInfo                   // Batch outcomes and tolerated failures.
+	LogLevelError                  // Failures only.
+	LogLevelSilent                 // Nothing at all.
+)
+
+// logLevelNames maps levels to their names.
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug:  "debug",
+	LogLevelInfo:   "info",
+	LogLevelError:  "error",
+	LogLevelSilent: "silent",
+}
+
+// ParseLogLevel returns the level by its name: silent, error, info or debug.
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level: %q", name)
+}
+
+// String returns the name of the level.
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", l)
+}
+
+// enabled reports whether messages of the level are logged.
+func (cr *crawler) enabled(level LogLevel) bool {
+	return level < LogLevelSilent && level >= cr.config.LogLevel
+}
+
+// logf logs a message of the level in the manner of log.Printf.
+func (cr *crawler) logf(level LogLevel, format string, args ...interface{}) {
+	if cr.enabled(level) {
+		cr.logger.Printf(format, args...)
+	}
+}
+
+// logln logs a message of the level in the manner of log.Println.
+func (cr *crawler) logln(level LogLevel, args ...interface{}) {
+	if cr.enabled(level) {
+		cr.logger.Printf("%s", strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+	}
+}
+
+// debugf, infof and errorf log formatted messages of the corresponding level.
+func (cr *crawler) debugf(format string, args ...interface{}) {
+	cr.logf(LogLevelDebug, format, args...)
+}
+
+func (cr *crawler) infof(format string, args ...interface{}) {
+	cr.logf(LogLevelInfo, format, args...)
+}
+
+func (cr *crawler) errorf(format string, args ...interface{}) {
+	cr.logf(LogLevelError, format, args...)
+}
+
+// debugln, infoln and errorln log messages of the corresponding level.
+func (cr *crawler) debugln(args ...interface{}) {
+	cr.logln(LogLevelDebug, args...)
+}
+
+func (cr *crawler) infoln(args ...interface{}) {
+	cr.logln(LogLevelInfo, args...)
+}
+
+func (cr *crawler) errorln(args ...interface{}) {
+	cr.logln(LogLevelError, args...)
+}
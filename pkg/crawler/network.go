@@ -0,0 +1,127 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultSchemes are the schemes crawled unless AllowedSchemes is set.
+var defaultSchemes = []string{"http", "https"}
+
+// privateNetworks are the ranges BlockPrivateNetworks refuses on top of
+// loopback, link-local and unspecified addresses: RFC 1918, shared
+// address space of RFC 6598 and unique local IPv6 addresses.
+var privateNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// mustParseCIDRs parses the networks, it panics on invalid ones.
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// allowsScheme reports whether URLs of the scheme may be crawled.
+func (cr *crawler) allowsScheme(scheme string) bool {
+	schemes := cr.config.AllowedSchemes
+	if schemes == nil {
+		schemes = defaultSchemes
+	}
+	for _, allowed := range schemes {
+		if strings.EqualFold(allowed, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// privateIP reports whether the address belongs to a private network.
+func privateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrivateNetworks fails the batch if any of the hosts, given along with
+// the first URL of each, is a private network address or resolves to one.
+// Hosts are resolved at the same time, those that don't resolve are left for
+// their requests to fail. The dialer checks the addresses again, which covers
+// redirects and names that resolve differently by the time of the request.
+func (cr *crawler) checkPrivateNetworks(ctx context.Context, hosts map[string]string) error {
+	ctx, cancel := cr.withTimeout(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(hosts))
+	for host, u := range hosts {
+		go func(host, u string) {
+			errs <- checkPrivateHost(ctx, host, u)
+		}(host, u)
+	}
+
+	var firstErr error
+	for range hosts {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// checkPrivateHost fails if the host of the URL is a private network address
+// or resolves to one.
+func checkPrivateHost(ctx context.Context, host, u string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if privateIP(ip) {
+			return fmt.Errorf("%w: %q", ErrPrivateNetwork, u)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if privateIP(addr.IP) {
+			return fmt.Errorf("%w: %q resolves to %s", ErrPrivateNetwork, u, addr.IP)
+		}
+	}
+	return nil
+}
+
+// refusePrivateNetworks is a net.Dialer control function that refuses
+// connections to private network addresses, proxies included.
+func refusePrivateNetworks(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && privateIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateNetwork, ip)
+	}
+	return nil
+}
+
+// newNetDialer returns a dialer with the same timeouts as http.DefaultTransport.
+func newNetDialer() net.Dialer {
+	return net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+}
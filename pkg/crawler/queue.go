@@ -0,0 +1,129 @@
+package crawler
+
+import (
+	"container/heap"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+type (
+	// task is a single URL to crawl along with its place in the batch.
+	task struct {
+		index    int // Position in the submitted batch.
+		url      string
+		method   string
+		body     []byte
+		header   http.Header
+		host     string
+		priority int
+		round    int // Number of earlier tasks of the same host, set with fair queuing.
+	}
+	// queue hands out tasks to workers, higher priorities first and in
+	// submission order within the same priority, or round-robin by host
+	// with fair queuing.
+	queue struct {
+		sync.Mutex
+		tasks taskHeap
+
+		// backlog holds tasks waiting for an earlier task of the same
+		// host to be done, nil unless the queue limits tasks per host.
+		backlog map[string][]task
+	}
+	taskHeap []task
+)
+
+// Interface compliance check.
+var _ heap.Interface = (*taskHeap)(nil)
+
+// newQueue returns a queue filled with the tasks.
+func newQueue(tasks []task) *queue {
+	q := &queue{tasks: tasks}
+	heap.Init(&q.tasks)
+	return q
+}
+
+// newHostLimitedQueue returns a queue that hands out up to perHost tasks
+// per host at a time: the next task of a host is only available once one
+// of the previous ones is done.
+func newHostLimitedQueue(tasks []task, perHost int) *queue {
+	sorted := make(taskHeap, len(tasks))
+	copy(sorted, tasks)
+	sort.Sort(sorted)
+
+	q := &queue{backlog: make(map[string][]task)}
+	handedOut := make(map[string]int)
+	for _, t := range sorted {
+		if handedOut[t.host] >= perHost {
+			q.backlog[t.host] = append(q.backlog[t.host], t)
+			continue
+		}
+		handedOut[t.host]++
+		q.tasks = append(q.tasks, t)
+	}
+	heap.Init(&q.tasks)
+	return q
+}
+
+// interleaveHosts numbers the tasks of every host in submission order,
+// so that the first tasks of all hosts are handed out before the second ones.
+func interleaveHosts(tasks []task) {
+	rounds := make(map[string]int)
+	for i := range tasks {
+		tasks[i].round = rounds[tasks[i].host]
+		rounds[tasks[i].host]++
+	}
+}
+
+// pop returns the next task, false when the queue is empty.
+func (q *queue) pop() (task, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.tasks) == 0 {
+		return task{}, false
+	}
+	return heap.Pop(&q.tasks).(task), true
+}
+
+// done releases the host of a popped task, so that the next task of the
+// same host can be handed out.
+func (q *queue) done(t task) {
+	if q.backlog == nil {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	waiting := q.backlog[t.host]
+	if len(waiting) == 0 {
+		delete(q.backlog, t.host)
+		return
+	}
+	heap.Push(&q.tasks, waiting[0])
+	q.backlog[t.host] = waiting[1:]
+}
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	if h[i].round != h[j].round {
+		return h[i].round < h[j].round
+	}
+	return h[i].index < h[j].index
+}
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(task)) }
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
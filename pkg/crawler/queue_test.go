@@ -0,0 +1,72 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// popAll returns the URLs of the queue in the order it hands them out.
+func popAll(q *queue) (urls []string) {
+	for {
+		t, ok := q.pop()
+		if !ok {
+			return urls
+		}
+		urls = append(urls, t.url)
+	}
+}
+
+func TestFairQueuingInterleavesHosts(t *testing.T) {
+	hosts := []string{"big", "big", "big", "big", "small1", "big", "small2"}
+	newTasks := func() []task {
+		tasks := make([]task, len(hosts))
+		for i, host := range hosts {
+			tasks[i] = task{index: i, url: fmt.Sprintf("%s/%d", host, i), host: host}
+		}
+		return tasks
+	}
+
+	if got, want := popAll(newQueue(newTasks())), []string{
+		"big/0", "big/1", "big/2", "big/3", "small1/4", "big/5", "small2/6",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FIFO order = %q, want %q", got, want)
+	}
+
+	fair := newTasks()
+	interleaveHosts(fair)
+	if got, want := popAll(newQueue(fair)), []string{
+		"big/0", "small1/4", "small2/6", "big/1", "big/2", "big/3", "big/5",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fair order = %q, want %q", got, want)
+	}
+}
+
+func TestFairQueuingCrawlOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	big, small := record("big"), record("small")
+
+	cr := newTestCrawler(t, Config{MaxConnections: 1, FairQueuing: true})
+	urls := []string{big.URL + "/1", big.URL + "/2", big.URL + "/3", small.URL}
+	if _, err := cr.Crawl(context.Background(), urls); err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if want := []string{"big", "small", "big", "big"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("hosts crawled in order %q, want %q", order, want)
+	}
+}
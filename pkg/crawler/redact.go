@@ -0,0 +1,32 @@
+package crawler
+
+import (
+	"net/http"
+)
+
+// RedactedValue replaces values of sensitive headers.
+const RedactedValue = "***"
+
+// DefaultRedactHeaders are always redacted, Config.RedactHeaders adds to them.
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// RedactHeaders returns a copy of the headers with all values of the named
+// ones replaced by RedactedValue. Names are matched case-insensitively.
+// Whatever logs or returns headers goes through it, so that a single list
+// decides what never leaves the process.
+func RedactHeaders(h http.Header, names []string) http.Header {
+	redacted := h.Clone()
+	for _, name := range names {
+		if _, ok := redacted[http.CanonicalHeaderKey(name)]; ok {
+			redacted.Set(name, RedactedValue)
+		}
+	}
+	return redacted
+}
+
+// redact returns a copy of the headers with values of DefaultRedactHeaders
+// and RedactHeaders replaced, the same ones /config redacts.
+func (cr *crawler) redact(h http.Header) http.Header {
+	names := append(append([]string(nil), DefaultRedactHeaders...), cr.config.RedactHeaders...)
+	return RedactHeaders(h, names)
+}
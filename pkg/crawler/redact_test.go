@@ -0,0 +1,121 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger keeps the messages logged through it.
+type recordingLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) String() string {
+	l.Lock()
+	defer l.Unlock()
+	return strings.Join(l.lines, "")
+}
+
+func TestRedact(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Set-Cookie", "session=secret")
+	h.Set("X-Request-Id", "42")
+
+	tests := []struct {
+		name       string
+		names      []string
+		redacted   []string
+		unredacted []string
+	}{
+		{name: "nil redacts the defaults", names: nil, redacted: []string{"Authorization", "Set-Cookie"}, unredacted: []string{"X-Request-Id"}},
+		{name: "empty redacts the defaults", names: []string{}, redacted: []string{"Authorization", "Set-Cookie"}, unredacted: []string{"X-Request-Id"}},
+		{name: "custom list adds to the defaults", names: []string{"x-request-id"}, redacted: []string{"X-Request-Id", "Authorization", "Set-Cookie"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := &crawler{config: Config{RedactHeaders: tt.names}}
+			got := cr.redact(h)
+			for _, name := range tt.redacted {
+				if v := got.Get(name); v != RedactedValue {
+					t.Errorf("%s = %q, want %q", name, v, RedactedValue)
+				}
+			}
+			for _, name := range tt.unredacted {
+				if v := got.Get(name); v != h.Get(name) {
+					t.Errorf("%s = %q, want %q", name, v, h.Get(name))
+				}
+			}
+		})
+	}
+
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("redact modified the original headers")
+	}
+}
+
+func TestRedactLogsAndResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=response-secret")
+		w.Header().Set("X-Tenant", "tenant-secret")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer request-secret")
+	headers.Set("X-Tenant", "tenant-secret")
+
+	for _, names := range [][]string{nil, {"X-Tenant"}} {
+		t.Run(fmt.Sprint(names), func(t *testing.T) {
+			logger := &recordingLogger{}
+			c, err := NewWithConfig(Config{
+				Headers:       headers,
+				RedactHeaders: names,
+				LogBodies:     true,
+				LogLevel:      LogLevelDebug,
+				Logger:        logger,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			results, err := c.Crawl(context.Background(), []string{srv.URL})
+			if err != nil {
+				t.Fatalf("Crawl() error = %v", err)
+			}
+
+			logged, got := logger.String(), results[0].Headers
+			if !strings.Contains(logged, "crawler: debug: response:") {
+				t.Fatalf("no exchange logged: %s", logged)
+			}
+			for _, secret := range []string{"request-secret", "response-secret"} {
+				if strings.Contains(logged, secret) {
+					t.Errorf("log has the default redacted %q: %s", secret, logged)
+				}
+			}
+			if v := got.Get("Set-Cookie"); v != RedactedValue {
+				t.Errorf("Result.Headers Set-Cookie = %q, want %q", v, RedactedValue)
+			}
+
+			custom := names != nil
+			if strings.Contains(logged, "tenant-secret") == custom {
+				t.Errorf("X-Tenant redacted in the log = %t, want %t: %s", !custom, custom, logged)
+			}
+			if (got.Get("X-Tenant") == RedactedValue) != custom {
+				t.Errorf("Result.Headers X-Tenant = %q, want it redacted %t", got.Get("X-Tenant"), custom)
+			}
+		})
+	}
+}
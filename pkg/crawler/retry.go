@@ -0,0 +1,96 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRetryBackoff caps the pause between retries.
+const maxRetryBackoff = time.Minute
+
+type (
+	// sendError fails requests that got no response at all.
+	sendError struct {
+		err error
+	}
+	// statusError fails responses with a status other than 200.
+	statusError struct {
+		code int
+	}
+	// lockedRand is a random source the workers of a crawler share.
+	lockedRand struct {
+		sync.Mutex
+		rnd *rand.Rand
+	}
+)
+
+// newLockedRand returns a random source with the seed.
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// int63n returns a random number in [0, n).
+func (r *lockedRand) int63n(n int64) int64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.rnd.Int63n(n)
+}
+
+func (e *sendError) Error() string {
+	return "failed to send a request: " + e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected response status code: %d", e.code)
+}
+
+// backoff waits before the retry that follows the attempt: a random time up
+// to the backoff of the attempt, so that URLs failed at once aren't retried
+// all together. It returns false if ctx is done first.
+func (cr *crawler) backoff(ctx context.Context, attempt int) bool {
+	delay := cr.config.RetryBackoff
+	if delay <= 0 {
+		return true
+	}
+	for i := 0; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	delay = time.Duration(cr.random.int63n(int64(delay) + 1))
+
+	timer := cr.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+// retryable reports whether a failed request may succeed if sent again:
+// the upstream couldn't be reached, failed itself or asked for a retry.
+func retryable(err error) bool {
+	var sendErr *sendError
+	if errors.As(err, &sendErr) {
+		return !errors.Is(err, ErrHostNotAllowed) && !errors.Is(err, ErrPrivateNetwork) &&
+			!errors.Is(err, errTooManyRedirects)
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= http.StatusInternalServerError
+	}
+	return errors.Is(err, errRetryBody)
+}
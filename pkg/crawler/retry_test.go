@@ -0,0 +1,64 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryIfJSONInBandError(t *testing.T) {
+	// The upstream answers with 200 and an error in the body until it has
+	// been asked failures times.
+	newServer := func(failures int32) (*httptest.Server, *int32) {
+		var hits int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&hits, 1) <= failures {
+				_, _ = w.Write([]byte(`{"error":"rate_limited"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+		t.Cleanup(srv.Close)
+		return srv, &hits
+	}
+	rateLimited := func(body json.RawMessage) bool {
+		return bytes.Contains(body, []byte(`"rate_limited"`))
+	}
+
+	tests := []struct {
+		name     string
+		failures int32
+		retries  uint8
+		wantBody string
+		wantHits int32
+		wantErr  error
+	}{
+		{name: "retried until it passes", failures: 2, retries: 3, wantBody: `{"ok":true}`, wantHits: 3},
+		{name: "retries run out", failures: 5, retries: 2, wantHits: 3, wantErr: errRetryBody},
+		{name: "not checked without retries", failures: 1, wantBody: `{"error":"rate_limited"}`, wantHits: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := newServer(tt.failures)
+			cr := newTestCrawler(t, Config{ContinueOnError: true, MaxRetries: tt.retries, RetryIfJSON: rateLimited})
+
+			results, _ := cr.Crawl(context.Background(), []string{srv.URL})
+			if !errors.Is(results[0].Err(), tt.wantErr) {
+				t.Errorf("error = %v, want %v", results[0].Err(), tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				if got := string(results[0].ResponseBody); got != tt.wantBody {
+					t.Errorf("ResponseBody = %s, want %s", got, tt.wantBody)
+				}
+			}
+			if got := atomic.LoadInt32(hits); got != tt.wantHits {
+				t.Errorf("upstream asked %d times, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// salvageJSON returns the complete elements of a truncated JSON array, or the
+// complete values of NDJSON, as a JSON array. NDJSON that ends with a complete
+// value isn't reported as truncated. It fails if the body is neither, e.g.
+// a truncated object, if nothing could be salvaged from NDJSON, or if the
+// body is malformed rather than cut short: a complete array followed by more
+// data, or a value with a syntax error, stays invalid JSON.
+func salvageJSON(body []byte) (salvaged json.RawMessage, truncated, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+
+	var values []json.RawMessage
+	if trimmed := bytes.TrimSpace(body); bytes.HasPrefix(trimmed, []byte("[")) {
+		if _, err := dec.Token(); err != nil {
+			return nil, false, false
+		}
+		for dec.More() {
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				if !cutShort(err, len(body)) {
+					return nil, false, false
+				}
+				truncated = true
+				break
+			}
+			values = append(values, value)
+		}
+		if !truncated {
+			// The array either ends here or the body does, nothing may follow.
+			tok, err := dec.Token()
+			switch {
+			case cutShort(err, len(body)):
+				truncated = true
+			case err != nil || tok != json.Delim(']'):
+				return nil, false, false
+			default:
+				if _, err = dec.Token(); err != io.EOF {
+					return nil, false, false
+				}
+			}
+		}
+	} else {
+		for {
+			var value json.RawMessage
+			err := dec.Decode(&value)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if !cutShort(err, len(body)) {
+					return nil, false, false
+				}
+				truncated = true
+				break
+			}
+			values = append(values, value)
+		}
+		if len(values) == 0 {
+			return nil, false, false
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	buffer.WriteByte('[')
+	for i, value := range values {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(value)
+	}
+	buffer.WriteByte(']')
+	return buffer.Bytes(), truncated, true
+}
+
+// cutShort reports whether the decoder failed because the body of size
+// bytes ended in the middle of a value, as opposed to running into malformed
+// data. Depending on where the body ends, the decoder reports that either as
+// an unexpected EOF or as a syntax error at the end of the input.
+func cutShort(err error, size int) bool {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(size)
+}
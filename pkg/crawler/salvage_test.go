@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSalvageTruncatedArray(t *testing.T) {
+	srv := newTruncatedServer(t, `[1, {"a": 2}, {"b": [3`, 100)
+
+	cr := newTestCrawler(t, Config{SalvageTruncated: true})
+	results, err := cr.Crawl(context.Background(), []string{srv.URL})
+	if err != nil {
+		t.Fatalf("Crawl() error = %v", err)
+	}
+	if got, want := string(results[0].ResponseBody), `[1,{"a":2}]`; got != want {
+		t.Errorf("ResponseBody = %s, want %s", got, want)
+	}
+	if !results[0].Truncated {
+		t.Error("Truncated = false, want true")
+	}
+
+	strict := newTestCrawler(t, Config{})
+	if _, err := strict.Crawl(context.Background(), []string{srv.URL}); err == nil {
+		t.Error("without SalvageTruncated: Crawl() error = nil, want the truncated body to fail")
+	}
+}
+
+func TestSalvageJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		want          string
+		wantTruncated bool
+		wantOK        bool
+	}{
+		{name: "array cut in an element", body: `[1,2,{"a":`, want: `[1,2]`, wantTruncated: true, wantOK: true},
+		{name: "array cut after a comma", body: `[1,2,`, want: `[1,2]`, wantTruncated: true, wantOK: true},
+		{name: "array cut before the end", body: `[1,2`, want: `[1,2]`, wantTruncated: true, wantOK: true},
+		{name: "empty array cut", body: `[`, want: `[]`, wantTruncated: true, wantOK: true},
+		{name: "NDJSON cut in a value", body: "{\"a\":1}\n{\"b\":", want: `[{"a":1}]`, wantTruncated: true, wantOK: true},
+		{name: "complete NDJSON", body: "{\"a\":1}\n{\"b\":2}\n", want: `[{"a":1},{"b":2}]`, wantOK: true},
+		{name: "complete array", body: `[1,2]`, want: `[1,2]`, wantOK: true},
+		{name: "truncated object", body: `{"a":1,"b":`},
+		{name: "object followed by garbage", body: `{"a":1}garbage`},
+		{name: "NDJSON followed by garbage", body: "{\"a\":1}\n{\"b\":2}\nnot json"},
+		{name: "array followed by data", body: `[1,2]{"a":1}`},
+		{name: "array followed by garbage", body: `[1,2] garbage`},
+		{name: "array with a syntax error", body: `[1,2,x,3]`},
+		{name: "nothing to salvage", body: `{"a":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated, ok := salvageJSON([]byte(tt.body))
+			if ok != tt.wantOK {
+				t.Fatalf("salvageJSON(%q) ok = %t, want %t", tt.body, ok, tt.wantOK)
+			}
+			if string(got) != tt.want || truncated != tt.wantTruncated {
+				t.Errorf("salvageJSON(%q) = %s, %t, want %s, %t", tt.body, got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestSalvageKeepsTrailingGarbageInvalid(t *testing.T) {
+	for _, body := range []string{`{"a":1}garbage`, `[1,2]{"a":1}`} {
+		srv := newJSONServer(t, body)
+		cr := newTestCrawler(t, Config{SalvageTruncated: true})
+		if _, err := cr.Crawl(context.Background(), []string{srv.URL}); err == nil {
+			t.Errorf("%s: Crawl() error = nil, want invalid JSON", body)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package crawler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Body returns a reader for the response body, whether it is kept
+// in memory or was spilled to disk. The caller must close the reader.
+func (r Result) Body() (io.ReadCloser, error) {
+	if r.BodyPath == "" {
+		return ioutil.NopCloser(bytes.NewReader(r.ResponseBody)), nil
+	}
+	return os.Open(r.BodyPath)
+}
+
+// Cleanup removes the spilled body file, if any.
+func (r Result) Cleanup() error {
+	if r.BodyPath == "" {
+		return nil
+	}
+	if err := os.Remove(r.BodyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// spill writes the body to a new file in the directory and returns its path.
+func spill(dir string, body io.Reader) (_ string, err error) {
+	f, err := ioutil.TempFile(dir, "body-*.json")
+	if err != nil {
+		return "", fmt.Errorf("create temp file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close temp file: %w", closeErr)
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = io.Copy(f, body); err != nil {
+		return "", fmt.Errorf("write temp file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// cleanup removes spilled bodies of results that are not returned to the caller.
+func (cr *crawler) cleanup(results []Result) {
+	for _, res := range results {
+		if err := res.Cleanup(); err != nil {
+			cr.errorln("crawler: remove spilled body:", err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+type (
+	// CrawlerStats are counters collected over the lifetime of a crawler.
+	CrawlerStats struct {
+		NewConns    int64 // Requests sent over a newly dialed connection.
+		ReusedConns int64 // Requests sent over a connection from the idle pool.
+
+		// Requests is the number of requests crawled, every retry counted
+		// on its own. Each of them is counted again either as a success or
+		// as a failure by reason.
+		Requests       int64
+		Successes      int64
+		Timeouts       int64 // Requests that ran out of RequestTimeout or BatchTimeout.
+		Canceled       int64 // Requests canceled along with their batch.
+		StatusFailures int64 // Responses with a status that AcceptStatus rejected.
+		InvalidJSON    int64 // Responses with a body that isn't valid JSON.
+		OtherFailures  int64
+	}
+	// stats holds the counters of CrawlerStats, updated atomically.
+	stats struct {
+		newConns       int64
+		reusedConns    int64
+		requests       int64
+		successes      int64
+		timeouts       int64
+		canceled       int64
+		statusFailures int64
+		invalidJSON    int64
+		otherFailures  int64
+	}
+)
+
+// Stats returns a snapshot of the crawler counters. Connections are only
+// counted with CountConnReuse set. Counters are read one by one, so a
+// snapshot taken while requests are done may not add up exactly.
+func (cr *crawler) Stats() CrawlerStats {
+	return CrawlerStats{
+		NewConns:       atomic.LoadInt64(&cr.stats.newConns),
+		ReusedConns:    atomic.LoadInt64(&cr.stats.reusedConns),
+		Requests:       atomic.LoadInt64(&cr.stats.requests),
+		Successes:      atomic.LoadInt64(&cr.stats.successes),
+		Timeouts:       atomic.LoadInt64(&cr.stats.timeouts),
+		Canceled:       atomic.LoadInt64(&cr.stats.canceled),
+		StatusFailures: atomic.LoadInt64(&cr.stats.statusFailures),
+		InvalidJSON:    atomic.LoadInt64(&cr.stats.invalidJSON),
+		OtherFailures:  atomic.LoadInt64(&cr.stats.otherFailures),
+	}
+}
+
+// ConnReuseRate returns the share of requests that reused a connection,
+// zero if there were none.
+func (s CrawlerStats) ConnReuseRate() float64 {
+	total := s.NewConns + s.ReusedConns
+	if total == 0 {
+		return 0
+	}
+	return float64(s.ReusedConns) / float64(total)
+}
+
+// countConn records whether a request got a reused connection.
+func (s *stats) countConn(reused bool) {
+	if reused {
+		atomic.AddInt64(&s.reusedConns, 1)
+	} else {
+		atomic.AddInt64(&s.newConns, 1)
+	}
+}
+
+// countResult records the outcome of a request by the error it failed with.
+func (s *stats) countResult(err error) {
+	atomic.AddInt64(&s.requests, 1)
+
+	var netErr net.Error
+	var statusErr *statusError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case err == nil:
+		atomic.AddInt64(&s.successes, 1)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		atomic.AddInt64(&s.timeouts, 1)
+	case errors.Is(err, context.Canceled):
+		atomic.AddInt64(&s.canceled, 1)
+	case errors.As(err, &statusErr):
+		atomic.AddInt64(&s.statusFailures, 1)
+	case errors.As(err, &syntaxErr):
+		atomic.AddInt64(&s.invalidJSON, 1)
+	default:
+		atomic.AddInt64(&s.otherFailures, 1)
+	}
+}
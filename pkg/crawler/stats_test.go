@@ -0,0 +1,35 @@
+package crawler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCountConnReuse(t *testing.T) {
+	srv := newJSONServer(t, `{}`)
+
+	for _, count := range []bool{false, true} {
+		cr := newTestCrawler(t, Config{CountConnReuse: count})
+		for i := 0; i < 2; i++ {
+			if _, err := cr.Crawl(context.Background(), []string{srv.URL}); err != nil {
+				t.Fatalf("count %t: Crawl() %d error = %v", count, i, err)
+			}
+		}
+
+		stats := cr.Stats()
+		wantNew, wantReused, wantRate := int64(0), int64(0), 0.0
+		if count {
+			wantNew, wantReused, wantRate = 1, 1, 0.5
+		}
+		if stats.NewConns != wantNew || stats.ReusedConns != wantReused {
+			t.Errorf("count %t: NewConns = %d, ReusedConns = %d, want %d and %d",
+				count, stats.NewConns, stats.ReusedConns, wantNew, wantReused)
+		}
+		if got := stats.ConnReuseRate(); got != wantRate {
+			t.Errorf("count %t: ConnReuseRate() = %v, want %v", count, got, wantRate)
+		}
+		if stats.Requests != 2 || stats.Successes != 2 {
+			t.Errorf("count %t: Requests = %d, Successes = %d, want 2 of each", count, stats.Requests, stats.Successes)
+		}
+	}
+}
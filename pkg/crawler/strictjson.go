@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrStrictJSON fails bodies that StrictJSON rejects.
+var ErrStrictJSON = errors.New("body is not strict JSON")
+
+// checkStrictJSON fails bodies that have an object with a duplicate key or
+// anything but whitespace after the first JSON value.
+func checkStrictJSON(body []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	if err := checkStrictValue(dec, "$"); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("%w: data after the JSON value at offset %d", ErrStrictJSON, dec.InputOffset())
+	}
+	return nil
+}
+
+// checkStrictValue reads the next value off the decoder, path tells where it
+// is in the body for the error.
+func checkStrictValue(dec *json.Decoder, path string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		seen := make(map[string]bool)
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			if seen[key] {
+				return fmt.Errorf("%w: duplicate key %q in %s", ErrStrictJSON, key, path)
+			}
+			seen[key] = true
+			if err := checkStrictValue(dec, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := checkStrictValue(dec, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+
+	// Read the closing delimiter.
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,45 @@
+package crawler
+
+import "context"
+
+// traceParentHeader carries the span context of outgoing requests, see W3C Trace Context.
+const traceParentHeader = "traceparent"
+
+type (
+	// Tracer starts spans for batches and the requests sent for them. It has
+	// the shape of an OpenTelemetry tracer, so one can be plugged in with a
+	// thin adapter.
+	Tracer interface {
+		Start(ctx context.Context, name string) (context.Context, Span)
+	}
+	// Span is a single traced operation.
+	Span interface {
+		SetAttribute(key string, value interface{})
+		RecordError(err error)
+		End()
+
+		// TraceParent returns the traceparent header value to send with
+		// requests made within the span, empty means none is sent.
+		TraceParent() string
+	}
+	noopTracer struct{}
+	noopSpan   struct{}
+)
+
+// Interface compliance check.
+var (
+	_ Tracer = noopTracer{}
+	_ Span   = noopSpan{}
+)
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+func (noopSpan) RecordError(error) {}
+
+func (noopSpan) End() {}
+
+func (noopSpan) TraceParent() string { return "" }
@@ -0,0 +1,128 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type (
+	// recordingTracer keeps every span it starts.
+	recordingTracer struct {
+		sync.Mutex
+		spans []*recordedSpan
+	}
+	recordedSpan struct {
+		sync.Mutex
+		id         int
+		name       string
+		parent     *recordedSpan
+		attributes map[string]interface{}
+		errs       []error
+		ended      bool
+	}
+	spanKey struct{}
+)
+
+func (tr *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	tr.Lock()
+	defer tr.Unlock()
+	span := &recordedSpan{id: len(tr.spans) + 1, name: name, attributes: make(map[string]interface{})}
+	span.parent, _ = ctx.Value(spanKey{}).(*recordedSpan)
+	tr.spans = append(tr.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// named returns the spans of the name.
+func (tr *recordingTracer) named(name string) (spans []*recordedSpan) {
+	tr.Lock()
+	defer tr.Unlock()
+	for _, span := range tr.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.Lock()
+	s.attributes[key] = value
+	s.Unlock()
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.Lock()
+	s.errs = append(s.errs, err)
+	s.Unlock()
+}
+
+func (s *recordedSpan) End() {
+	s.Lock()
+	s.ended = true
+	s.Unlock()
+}
+
+func (s *recordedSpan) TraceParent() string {
+	return fmt.Sprintf("00-%032x-%016x-01", 1, s.id)
+}
+
+func TestTracerSpans(t *testing.T) {
+	var mu sync.Mutex
+	traceParents := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceParents[r.URL.Path] = r.Header.Get(traceParentHeader)
+		mu.Unlock()
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tracer := &recordingTracer{}
+	cr := newTestCrawler(t, Config{ContinueOnError: true, Tracer: tracer})
+	_, _ = cr.Crawl(context.Background(), []string{srv.URL + "/a", srv.URL + "/bad"})
+
+	batches := tracer.named("crawler.crawl")
+	if len(batches) != 1 {
+		t.Fatalf("got %d batch spans, want 1", len(batches))
+	}
+	batch := batches[0]
+	if !batch.ended || batch.attributes["crawler.urls"] != 2 || batch.attributes["crawler.results"] != 2 {
+		t.Errorf("batch span: ended %t, attributes %v", batch.ended, batch.attributes)
+	}
+
+	requests := tracer.named("crawler.request")
+	if len(requests) != 2 {
+		t.Fatalf("got %d request spans, want 2", len(requests))
+	}
+	for _, span := range requests {
+		url, _ := span.attributes["http.url"].(string)
+		if span.parent != batch {
+			t.Errorf("%s: span isn't a child of the batch span", url)
+		}
+		if !span.ended {
+			t.Errorf("%s: span not ended", url)
+		}
+		wantStatus, wantErrs := http.StatusOK, 0
+		if url == srv.URL+"/bad" {
+			wantStatus, wantErrs = http.StatusBadGateway, 1
+		}
+		if span.attributes["http.status_code"] != wantStatus {
+			t.Errorf("%s: http.status_code = %v, want %d", url, span.attributes["http.status_code"], wantStatus)
+		}
+		if len(span.errs) != wantErrs {
+			t.Errorf("%s: recorded %d errors, want %d", url, len(span.errs), wantErrs)
+		}
+		path := url[len(srv.URL):]
+		if got := traceParents[path]; got != span.TraceParent() {
+			t.Errorf("%s: %s = %q, want %q", url, traceParentHeader, got, span.TraceParent())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sequenced is a result tagged with the order it left the crawl workers in.
+type sequenced struct {
+	seq int
+	res Result
+}
+
+// transform applies the Transform hook to a successful result. A failed
+// transform fails the URL, its spilled body is removed right away.
+func (cr *crawler) transform(res Result) Result {
+	if res.err != nil {
+		return res
+	}
+
+	out, err := cr.config.Transform(res)
+	if err != nil {
+		cr.errorln("crawler: transform result:", err)
+		cr.cleanup([]Result{res})
+		return Result{SourceURL: res.SourceURL, index: res.index, err: fmt.Errorf("transform result: %w", err)}
+	}
+	out.SourceURL, out.index = res.SourceURL, res.index
+	return out
+}
+
+// transformStage runs the Transform hook on results in TransformWorkers
+// goroutines and passes them on in the order they were received. The
+// returned channel is closed once in is closed and drained.
+func (cr *crawler) transformStage(in <-chan Result) <-chan Result {
+	jobs := make(chan sequenced)
+	transformed := make(chan sequenced)
+	out := make(chan Result)
+
+	go func() {
+		defer close(jobs)
+		seq := 0
+		for res := range in {
+			jobs <- sequenced{seq: seq, res: res}
+			seq++
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(cr.config.TransformWorkers)
+	for i := 0; i < cr.config.TransformWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				job.res = cr.transform(job.res)
+				transformed <- job
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(transformed)
+	}()
+
+	// Hold back results that overtook earlier ones.
+	go func() {
+		defer close(out)
+		pending := make(map[int]Result)
+		next := 0
+		for job := range transformed {
+			pending[job.seq] = job.res
+			for res, ok := pending[next]; ok; res, ok = pending[next] {
+				delete(pending, next)
+				out <- res
+				next++
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// burnCPU hashes the body over and over, standing in for an expensive
+// Transform.
+func burnCPU(body []byte, rounds int) []byte {
+	sum := sha256.Sum256(body)
+	for i := 1; i < rounds; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum[:]
+}
+
+// newPathServer returns a server that answers with the path as a JSON string.
+func newPathServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strconv.Quote(r.URL.Path)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTransformStageKeepsOrder(t *testing.T) {
+	const results = 20
+	cr := newTestCrawler(t, Config{
+		TransformWorkers: 4,
+		Transform: func(res Result) (Result, error) {
+			// Earlier results take longer, so later ones overtake them.
+			time.Sleep(time.Duration(results-res.index) * time.Millisecond)
+			burnCPU(res.ResponseBody, 1000)
+			if res.index%5 == 0 {
+				return res, errors.New("odd one out")
+			}
+			return res, nil
+		},
+	})
+
+	in := make(chan Result)
+	go func() {
+		defer close(in)
+		for i := 0; i < results; i++ {
+			in <- Result{SourceURL: strconv.Itoa(i), index: i}
+		}
+	}()
+
+	i := 0
+	for res := range cr.transformStage(in) {
+		if res.index != i || res.SourceURL != strconv.Itoa(i) {
+			t.Fatalf("result %d is the one of index %d, URL %s", i, res.index, res.SourceURL)
+		}
+		if failed := i%5 == 0; failed != (res.err != nil) {
+			t.Errorf("result %d: error = %v, want failed %t", i, res.err, failed)
+		}
+		i++
+	}
+	if i != results {
+		t.Errorf("got %d results, want %d", i, results)
+	}
+}
+
+func TestTransformWorkers(t *testing.T) {
+	srv := newPathServer(t)
+	urls := make([]string, 12)
+	for i := range urls {
+		urls[i] = srv.URL + "/" + strconv.Itoa(i)
+	}
+
+	cr := newTestCrawler(t, Config{
+		ContinueOnError:  true,
+		TransformWorkers: 3,
+		Transform: func(res Result) (Result, error) {
+			if strings.HasSuffix(res.SourceURL, "/3") || strings.HasSuffix(res.SourceURL, "/7") {
+				return res, errors.New("rejected by transform")
+			}
+			burnCPU(res.ResponseBody, 10000)
+			res.ResponseBody = []byte(strings.ToUpper(string(res.ResponseBody)))
+			return res, nil
+		},
+	})
+	results, err := cr.Crawl(context.Background(), urls)
+
+	var failed CrawlErrors
+	if !errors.As(err, &failed) || len(failed) != 2 || failed[0].Index != 3 || failed[1].Index != 7 {
+		t.Fatalf("Crawl() error = %v, want the failures of URLs 3 and 7", err)
+	}
+	if len(results) != len(urls) {
+		t.Fatalf("Crawl() returned %d results, want %d", len(results), len(urls))
+	}
+	for i, res := range results {
+		if res.SourceURL != urls[i] {
+			t.Errorf("result %d is of %s, want %s", i, res.SourceURL, urls[i])
+		}
+		if i == 3 || i == 7 {
+			if res.Err() == nil {
+				t.Errorf("result %d: error = nil, want the transform's", i)
+			}
+			continue
+		}
+		if want := strconv.Quote("/" + strconv.Itoa(i)); string(res.ResponseBody) != strings.ToUpper(want) {
+			t.Errorf("result %d: ResponseBody = %s, want %s", i, res.ResponseBody, want)
+		}
+	}
+}
+
+func BenchmarkTransform(b *testing.B) {
+	srv := newPathServer(b)
+	urls := make([]string, 64)
+	for i := range urls {
+		urls[i] = srv.URL + "/" + strconv.Itoa(i)
+	}
+	transform := func(res Result) (Result, error) {
+		burnCPU(res.ResponseBody, 20000)
+		return res, nil
+	}
+
+	for _, bm := range []struct {
+		name    string
+		workers int
+	}{
+		{name: "inline", workers: 0},
+		{name: "workers", workers: runtime.GOMAXPROCS(0)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			c, err := NewWithConfig(Config{
+				MaxConnections:   2,
+				LogLevel:         LogLevelSilent,
+				Transform:        transform,
+				TransformWorkers: bm.workers,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.(*crawler).CloseIdleConnections()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.Crawl(context.Background(), urls); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
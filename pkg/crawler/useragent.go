@@ -0,0 +1,30 @@
+package crawler
+
+import "context"
+
+// defaultUserAgent is sent unless UserAgent or the headers set another one.
+const defaultUserAgent = "multiplexer/1.0"
+
+// userAgentKey is the context key of the User-Agent of a batch.
+type userAgentKey struct{}
+
+// WithUserAgent returns ctx that makes batches crawled with it send the
+// User-Agent instead of the configured one, so that a crawler can pass for
+// different clients. Headers of single requests still win over it.
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+// batchUserAgent returns the User-Agent WithUserAgent set on ctx, if any.
+func batchUserAgent(ctx context.Context) (string, bool) {
+	userAgent, ok := ctx.Value(userAgentKey{}).(string)
+	return userAgent, ok && userAgent != ""
+}
+
+// userAgent returns the configured User-Agent.
+func (cr *crawler) userAgent() string {
+	if cr.config.UserAgent != "" {
+		return cr.config.UserAgent
+	}
+	return defaultUserAgent
+}
@@ -0,0 +1,61 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	uas := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uas <- r.UserAgent()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		cfg    Config
+		batch  string
+		header http.Header
+		want   string
+	}{
+		{name: "default", want: defaultUserAgent},
+		{name: "configured", cfg: Config{UserAgent: "configured/1.0"}, want: "configured/1.0"},
+		{
+			name: "headers over configured",
+			cfg:  Config{UserAgent: "configured/1.0", Headers: http.Header{"User-Agent": {"headers/1.0"}}},
+			want: "headers/1.0",
+		},
+		{
+			name:  "batch over headers",
+			cfg:   Config{UserAgent: "configured/1.0", Headers: http.Header{"User-Agent": {"headers/1.0"}}},
+			batch: "batch/1.0",
+			want:  "batch/1.0",
+		},
+		{
+			name:   "request over batch",
+			cfg:    Config{Headers: http.Header{"User-Agent": {"headers/1.0"}}},
+			batch:  "batch/1.0",
+			header: http.Header{"User-Agent": {"request/1.0"}},
+			want:   "request/1.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.batch != "" {
+				ctx = WithUserAgent(ctx, tt.batch)
+			}
+			cr := newTestCrawler(t, tt.cfg)
+			if _, err := cr.CrawlBatch(ctx, []Request{{URL: srv.URL, Header: tt.header}}); err != nil {
+				t.Fatalf("CrawlBatch() error = %v", err)
+			}
+			if got := <-uas; got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
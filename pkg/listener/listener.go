@@ -1,21 +1,72 @@
 package listener
 
 import (
+	"context"
+	"log"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/alexeykhan/multiplexer/pkg/clock"
 	"github.com/alexeykhan/multiplexer/pkg/ratelimiter"
 )
 
+// rejectLogInterval is the least time between two log messages about
+// rejected connections, the ones in between are only counted.
+const rejectLogInterval = 10 * time.Second
+
 type (
+	Config struct {
+		MaxConnections uint16 // Number of simultaneous connections, zero means unlimited.
+
+		// RejectOverflow closes connections that arrive while all
+		// MaxConnections spots are taken, instead of keeping them in the
+		// OS accept queue until a spot is released. There is no backlog
+		// on top of MaxConnections: the first connection over it is
+		// rejected. Rejections are logged with their count at most once
+		// every rejectLogInterval. Rate limiters that don't implement
+		// ratelimiter.TryAcquirer keep queueing.
+		RejectOverflow bool
+
+		// WriteTimeout bounds every write to a connection, zero means no
+		// limit. The deadline is reset on each write, so a response that is
+		// streamed in chunks may take longer as long as the client keeps
+		// reading, while a client that stopped reading is cut off.
+		WriteTimeout time.Duration
+
+		// KeepAlivePeriod is the interval of TCP keep-alive probes on
+		// accepted connections, so that dead peers are detected and their
+		// spots released instead of being held by half-open connections.
+		// Zero means the default of 15 seconds, negative disables probes.
+		KeepAlivePeriod time.Duration
+
+		// Clock measures rejectLogInterval and sets write deadlines, the
+		// system clock if nil. The OS checks the deadlines against the
+		// system time, so a fake clock breaks writes under WriteTimeout.
+		Clock clock.Clock
+	}
 	listener struct {
 		net.Listener
 		ratelimiter.RateLimiter
+
+		config   Config
+		clock    clock.Clock
+		once     sync.Once
+		closed   int32
+		closeErr error
+
+		// Rejected connections not logged yet and when they were last logged.
+		rejectMu     sync.Mutex
+		rejected     int
+		rejectLogged time.Time
 	}
 	connection struct {
 		net.Conn
-		once    sync.Once
-		release func()
+		once         sync.Once
+		release      func()
+		clock        clock.Clock
+		writeTimeout time.Duration
 	}
 )
 
@@ -29,36 +80,138 @@ var (
 
 // New returns a net.Listener with built-in rate limiter for {limit} concurrent requests.
 // A default net.Listener is returned if limit equals to zero.
-func New(network, address string, limit uint16) (lstnr net.Listener, err error) {
-	if lstnr, err = net.Listen(network, address); err != nil {
+func New(network, address string, limit uint16) (net.Listener, error) {
+	return NewWithConfig(network, address, Config{MaxConnections: limit})
+}
+
+// NewWithConfig returns a net.Listener with custom settings. A default
+// net.Listener is returned if neither MaxConnections nor WriteTimeout is set.
+func NewWithConfig(network, address string, cfg Config) (lstnr net.Listener, err error) {
+	lc := net.ListenConfig{KeepAlive: cfg.KeepAlivePeriod}
+	if lstnr, err = lc.Listen(context.Background(), network, address); err != nil {
 		return nil, err
 	}
-	if limit == 0 {
+	if cfg.MaxConnections == 0 && cfg.WriteTimeout == 0 {
 		return
 	}
-	return &listener{
-		Listener:    lstnr,
-		RateLimiter: ratelimiter.New(uint64(limit)),
-	}, nil
+
+	l := &listener{Listener: lstnr, config: cfg, clock: clock.OrNew(cfg.Clock)}
+	if cfg.MaxConnections > 0 {
+		l.RateLimiter = ratelimiter.New(uint64(cfg.MaxConnections))
+	}
+	return l, nil
 }
 
 // Accept waits for and returns the next connection to the listener.
+// Errors of the underlying listener are returned as they are, so that
+// http.Server keeps serving after temporary ones. Once the listener is
+// closed, Accept fails with net.ErrClosed.
 func (rl *listener) Accept() (conn net.Conn, err error) {
-	acquiredLock := rl.RateLimiter.Acquire()
-	if conn, err = rl.Listener.Accept(); err != nil {
-		if acquiredLock {
-			rl.RateLimiter.Release()
+	if rl.RateLimiter == nil {
+		if conn, err = rl.Listener.Accept(); err != nil {
+			return nil, err
 		}
+		return rl.wrap(conn, func() {}), nil
+	}
+	if tryAcquirer, ok := rl.RateLimiter.(ratelimiter.TryAcquirer); ok && rl.config.RejectOverflow {
+		return rl.acceptOrReject(tryAcquirer)
+	}
+
+	if !rl.RateLimiter.Acquire() {
+		return nil, rl.errClosed()
+	}
+	if conn, err = rl.Listener.Accept(); err != nil {
+		rl.RateLimiter.Release()
 		return nil, err
 	}
-	return &connection{Conn: conn, release: rl.RateLimiter.Release}, nil
+	return rl.wrap(conn, rl.RateLimiter.Release), nil
 }
 
-// Close closes the listener.
+// acceptOrReject returns the next connection that gets a free spot,
+// connections that arrive with the window full are closed right away.
+func (rl *listener) acceptOrReject(tryAcquirer ratelimiter.TryAcquirer) (net.Conn, error) {
+	for {
+		conn, err := rl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if tryAcquirer.TryAcquire() {
+			return rl.wrap(conn, rl.RateLimiter.Release), nil
+		}
+		if atomic.LoadInt32(&rl.closed) == 1 {
+			if err = conn.Close(); err != nil {
+				log.Println("listener: close rejected connection:", err)
+			}
+			return nil, rl.errClosed()
+		}
+		rl.logRejected(conn.RemoteAddr())
+		if err = conn.Close(); err != nil {
+			log.Println("listener: close rejected connection:", err)
+		}
+	}
+}
+
+// logRejected counts a rejected connection. A flood of them is logged as
+// a single message per rejectLogInterval with the number of connections
+// rejected since the previous one.
+func (rl *listener) logRejected(addr net.Addr) {
+	rl.rejectMu.Lock()
+	defer rl.rejectMu.Unlock()
+
+	rl.rejected++
+	now := rl.clock.Now()
+	if now.Sub(rl.rejectLogged) < rejectLogInterval {
+		return
+	}
+	log.Printf("listener: too many connections: rejected %d, the last one from %s\n", rl.rejected, addr.String())
+	rl.rejected, rl.rejectLogged = 0, now
+}
+
+// flushRejected logs the rejected connections that are only counted so far.
+func (rl *listener) flushRejected() {
+	rl.rejectMu.Lock()
+	defer rl.rejectMu.Unlock()
+
+	if rl.rejected > 0 {
+		log.Printf("listener: too many connections: rejected %d more\n", rl.rejected)
+		rl.rejected = 0
+	}
+}
+
+// errClosed returns the error of Accept on a closed listener.
+func (rl *listener) errClosed() error {
+	addr := rl.Listener.Addr()
+	return &net.OpError{Op: "accept", Net: addr.Network(), Addr: addr, Err: net.ErrClosed}
+}
+
+// wrap returns the connection with the listener settings applied,
+// release is called once the connection is closed.
+func (rl *listener) wrap(conn net.Conn, release func()) net.Conn {
+	return &connection{Conn: conn, release: release, clock: rl.clock, writeTimeout: rl.config.WriteTimeout}
+}
+
+// Close closes the listener. It is safe to call Close more than once,
+// subsequent calls return the result of the first one.
 func (rl *listener) Close() error {
-	err := rl.Listener.Close()
-	rl.RateLimiter.Done()
-	return err
+	rl.once.Do(func() {
+		atomic.StoreInt32(&rl.closed, 1)
+		rl.closeErr = rl.Listener.Close()
+		if rl.RateLimiter != nil {
+			rl.RateLimiter.Done()
+		}
+		rl.flushRejected()
+	})
+	return rl.closeErr
+}
+
+// Write writes data to the connection within WriteTimeout, if set.
+func (cn *connection) Write(b []byte) (int, error) {
+	if cn.writeTimeout > 0 {
+		if err := cn.Conn.SetWriteDeadline(cn.clock.Now().Add(cn.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return cn.Conn.Write(b)
 }
 
 // Close closes the connection.
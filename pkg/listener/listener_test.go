@@ -0,0 +1,339 @@
+package listener
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexeykhan/multiplexer/pkg/clock"
+	"github.com/alexeykhan/multiplexer/pkg/ratelimiter"
+)
+
+type (
+	// temporaryError is an accept error that http.Server retries.
+	temporaryError struct{}
+
+	// flakyListener fails the first accepts with a temporary error.
+	flakyListener struct {
+		net.Listener
+		failures int32
+	}
+)
+
+func (temporaryError) Error() string   { return "temporary accept error" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func (fl *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&fl.failures, -1) >= 0 {
+		return nil, temporaryError{}
+	}
+	return fl.Listener.Accept()
+}
+
+// serveWrites accepts one connection and writes chunks of data to it until
+// total bytes are written or a write fails, the result is sent on the channel.
+func serveWrites(t *testing.T, l net.Listener, chunk, total int) <-chan error {
+	t.Helper()
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		data := make([]byte, chunk)
+		for written := 0; written < total; written += chunk {
+			if _, err = conn.Write(data); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	return errs
+}
+
+func TestWriteTimeout(t *testing.T) {
+	const writeTimeout = 200 * time.Millisecond
+
+	t.Run("slow reader", func(t *testing.T) {
+		l, err := NewWithConfig("tcp", "127.0.0.1:0", Config{WriteTimeout: writeTimeout})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		const total = 16 << 20
+		errs := serveWrites(t, l, 32<<10, total)
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// The client keeps reading, just slower than the server writes: at
+		// least 64 reads 5ms apart take longer than the timeout as a whole.
+		buf := make([]byte, 256<<10)
+		var read int
+		for read < total {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("read after %d bytes: %v", read, err)
+			}
+			read += n
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err := <-errs; err != nil {
+			t.Errorf("write error = %v, want none", err)
+		}
+	})
+
+	t.Run("stuck reader", func(t *testing.T) {
+		l, err := NewWithConfig("tcp", "127.0.0.1:0", Config{WriteTimeout: writeTimeout})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		errs := serveWrites(t, l, 32<<10, 1<<30)
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// The client never reads: once the socket buffers are full the
+		// write is cut off.
+		select {
+		case err := <-errs:
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Errorf("write error = %v, want a timeout", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("write to a stuck reader didn't time out")
+		}
+	})
+}
+
+func TestAcceptTemporaryError(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listener{
+		Listener:    &flakyListener{Listener: inner, failures: 2},
+		RateLimiter: ratelimiter.New(1),
+		config:      Config{MaxConnections: 1},
+		clock:       clock.New(),
+	}
+	defer l.Close()
+
+	// The error is passed on as it is, and the spot it took is released.
+	for i := 0; i < 2; i++ {
+		_, err := l.Accept()
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Temporary() {
+			t.Fatalf("Accept() error = %v, want a temporary one", err)
+		}
+	}
+
+	// http.Server keeps serving after temporary errors.
+	l.Listener.(*flakyListener).failures = 1
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("GET after a temporary accept error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	select {
+	case err := <-served:
+		t.Fatalf("Serve() returned %v, want it to keep serving", err)
+	default:
+	}
+}
+
+func TestRejectOverflowLogsRateLimited(t *testing.T) {
+	var logged bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logged)
+
+	l, err := NewWithConfig("tcp", "127.0.0.1:0", Config{MaxConnections: 1, RejectOverflow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first connection takes the only spot, the rest are rejected.
+	const rejected = 5
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	held, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	buf := make([]byte, 1)
+	for i := 0; i < rejected; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A rejected connection is closed by the server.
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err = c.Read(buf); err == nil {
+			t.Errorf("connection %d: read succeeded, want it closed", i)
+		}
+		_ = c.Close()
+	}
+	_ = l.Close()
+
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want the first rejection and a summary: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "rejected 1,") {
+		t.Errorf("first line = %q, want the first rejection", lines[0])
+	}
+	if !strings.Contains(lines[1], "rejected 4 more") {
+		t.Errorf("second line = %q, want the count of the other rejections", lines[1])
+	}
+}
+
+// blockingLimiter hides TryAcquire of the rate limiter it wraps.
+type blockingLimiter struct {
+	ratelimiter.RateLimiter
+}
+
+func TestRejectOverflowWithoutTryAcquirerQueues(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listener{
+		Listener:    inner,
+		RateLimiter: blockingLimiter{ratelimiter.New(1)},
+		config:      Config{MaxConnections: 1, RejectOverflow: true},
+		clock:       clock.New(),
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	first := <-accepted
+	select {
+	case conn := <-accepted:
+		_ = conn.Close()
+		t.Fatal("second connection accepted while the only spot is taken")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The queued connection gets the spot once it's released.
+	_ = first.Close()
+	select {
+	case conn := <-accepted:
+		_ = conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued connection not accepted after the spot was released")
+	}
+}
+
+func TestRejectOverflowLogsAfterInterval(t *testing.T) {
+	var logged bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logged)
+
+	fake := clock.NewFake(time.Unix(0, 0).Add(rejectLogInterval))
+	l, err := NewWithConfig("tcp", "127.0.0.1:0", Config{MaxConnections: 1, RejectOverflow: true, Clock: fake})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	held, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	reject := func() {
+		t.Helper()
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err = c.Read(make([]byte, 1)); err == nil {
+			t.Fatal("read succeeded, want the connection closed")
+		}
+	}
+
+	// The first rejection is logged, the second one only counted until
+	// the interval passes and the third one is logged along with it.
+	reject()
+	reject()
+	fake.Advance(rejectLogInterval)
+	reject()
+	_ = l.Close()
+
+	lines := strings.Split(strings.TrimSpace(logged.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want one per interval: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[1], "rejected 2,") {
+		t.Errorf("second line = %q, want the rejections since the first one", lines[1])
+	}
+}
@@ -10,6 +10,13 @@ type (
 		Acquire() bool
 		Release()
 	}
+
+	// TryAcquirer is implemented by rate limiters that can book a spot
+	// without waiting for one. It's kept out of RateLimiter so that its
+	// implementations don't have to provide it.
+	TryAcquirer interface {
+		TryAcquire() bool
+	}
 	rateLimiter struct {
 		window chan struct{}
 		done   chan struct{}
@@ -18,7 +25,10 @@ type (
 )
 
 // Interface compliance check.
-var _ RateLimiter = (*rateLimiter)(nil)
+var (
+	_ RateLimiter = (*rateLimiter)(nil)
+	_ TryAcquirer = (*rateLimiter)(nil)
+)
 
 // New returns a RateLimiter instance.
 func New(limit uint64) RateLimiter {
@@ -45,6 +55,22 @@ func (rl *rateLimiter) Acquire() bool {
 	}
 }
 
+// TryAcquire books a free spot in the window without waiting for one.
+func (rl *rateLimiter) TryAcquire() bool {
+	select {
+	case <-rl.done:
+		return false
+	default:
+	}
+
+	select {
+	case rl.window <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
 // Release releases a spot in the window.
 func (rl *rateLimiter) Release() {
 	<-rl.window